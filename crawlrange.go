@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// --- Bounded Crawls ---
+
+// crawlRange bounds a crawl by gid or posted date, given by --from and --to.
+// Zero values leave a side open. Gid bounds are inclusive; date bounds work
+// like --posted-from/--posted-to: from the start of the from day up to the
+// start of the to day.
+type crawlRange struct {
+	fromGid, toGid       int64
+	fromPosted, toPosted int64  // unix seconds
+	fromDate, toDate     string // YYYY-MM-DD, for the listing's seek parameter
+	backward             bool   // crawl from the upper bound down to the lower one
+}
+
+// parseCrawlBound reads a gid or a YYYY-MM-DD date.
+func parseCrawlBound(name, value string) (gid int64, posted int64, err error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	if gid, err := strconv.ParseInt(value, 10, 64); err == nil && gid > 0 {
+		return gid, 0, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --%s %q, expected a gid or YYYY-MM-DD", name, value)
+	}
+	return 0, t.Unix(), nil
+}
+
+func parseCrawlRange(from, to string, backward bool) (*crawlRange, error) {
+	r := &crawlRange{backward: backward}
+	var err error
+	if r.fromGid, r.fromPosted, err = parseCrawlBound("from", from); err != nil {
+		return nil, err
+	}
+	if r.toGid, r.toPosted, err = parseCrawlBound("to", to); err != nil {
+		return nil, err
+	}
+	if r.fromPosted != 0 {
+		r.fromDate = from
+	}
+	if r.toPosted != 0 {
+		r.toDate = to
+	}
+	if r.fromGid > 0 && r.toGid > 0 && r.fromGid > r.toGid || r.fromPosted != 0 && r.toPosted != 0 && r.fromPosted >= r.toPosted {
+		return nil, fmt.Errorf("--from %s is not before --to %s", from, to)
+	}
+	return r, nil
+}
+
+// position tells whether a listing entry lies before (-1), inside (0) or after
+// (1) the range. Entries whose date can't be read only count by gid.
+func (r *crawlRange) position(e PageEntry) int {
+	gid, _ := strconv.ParseInt(e.GID, 10, 64)
+	posted := int64(-1)
+	if t, err := time.Parse("2006-01-02 15:04", e.Posted); err == nil {
+		posted = t.Unix()
+	}
+	switch {
+	case r.fromGid > 0 && gid < r.fromGid, r.fromPosted != 0 && posted >= 0 && posted < r.fromPosted:
+		return -1
+	case r.toGid > 0 && gid > r.toGid, r.toPosted != 0 && posted >= r.toPosted:
+		return 1
+	}
+	return 0
+}
+
+// filter returns the entries of a listing page inside the range, and whether
+// the page already reaches past the end the crawl is heading to. Pages list the
+// newest entry first.
+func (r *crawlRange) filter(entries []PageEntry) ([]PageEntry, bool) {
+	var inside []PageEntry
+	for _, e := range entries {
+		if r.position(e) == 0 {
+			inside = append(inside, e)
+		}
+	}
+	if len(entries) == 0 {
+		return inside, true
+	}
+	if r.backward {
+		return inside, r.position(entries[len(entries)-1]) < 0
+	}
+	return inside, r.position(entries[0]) > 0
+}
+
+// rangeCursor returns the listing cursor a bounded crawl of p starts at. A
+// forward crawl from a date seeks to that date and starts after the newest
+// gallery posted before it.
+func (s *Sync) rangeCursor(p *CrawlProfile, r *crawlRange) (string, int64, error) {
+	if r.backward {
+		switch {
+		case r.toGid > 0:
+			return "next=" + strconv.FormatInt(r.toGid+1, 10), r.toGid + 1, nil
+		case r.toDate != "":
+			return "seek=" + r.toDate, 0, nil
+		}
+		return "", 0, nil // the newest galleries
+	}
+
+	var startGid int64
+	var err error
+	switch {
+	case r.fromGid > 0:
+		startGid = r.fromGid - 1
+	case r.fromDate != "":
+		startGid, err = s.seekGid(p, r)
+	case p.Expunged:
+		startGid, err = s.getLastExpungedGid()
+	default:
+		startGid, err = s.getLastGid()
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return "prev=" + strconv.FormatInt(startGid, 10), startGid, nil
+}
+
+// seekGid finds the newest gallery posted before the from date with the
+// listing's seek parameter, so a forward crawl can start right after it.
+func (s *Sync) seekGid(p *CrawlProfile, r *crawlRange) (int64, error) {
+	var fetchURL string
+	var entries []PageEntry
+	var err error
+	for attempt := 0; attempt < s.config.RetryCount; attempt++ {
+		fetchURL, entries, err = s.getListingPage("seek="+r.fromDate, p)
+		if err == nil {
+			break
+		}
+		logFields{"url", fetchURL, "attempt", attempt + 1}.errorLog("Error seeking to %s on attempt %d: %v", r.fromDate, attempt+1, err)
+		time.Sleep(1 * time.Second)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("seeking to %s: %w", r.fromDate, err)
+	}
+	if len(entries) == 0 {
+		return 0, nil // nothing was posted before the date
+	}
+	var best, oldest int64 = -1, -1
+	for _, e := range entries {
+		gid, err := strconv.ParseInt(e.GID, 10, 64)
+		if err != nil {
+			continue
+		}
+		if r.position(e) < 0 && gid > best {
+			best = gid
+		}
+		if oldest < 0 || gid < oldest {
+			oldest = gid
+		}
+	}
+	if best < 0 {
+		// The whole page is on or after the date; start below it.
+		best = oldest - 1
+	}
+	debugLog("Seek to %s resolved to gid %d", r.fromDate, best)
+	return best, nil
+}
+
+// runBounded runs the normal and/or expunged pass within the --from/--to range.
+func (s *Sync) runBounded() error {
+	var passes []*CrawlProfile
+	if !s.onlyExpunged {
+		passes = append(passes, &CrawlProfile{Search: s.search})
+	}
+	if s.onlyExpunged || s.alsoExpunged {
+		passes = append(passes, &CrawlProfile{Search: s.search, Expunged: true})
+	}
+	var crawlErr error
+	for _, p := range passes {
+		cursor, startGid, err := s.rangeCursor(p, s.bounds)
+		if err != nil {
+			return err
+		}
+		kind := "normal"
+		if p.Expunged {
+			kind = "expunged"
+		}
+		direction := "forward"
+		if s.bounds.backward {
+			direction = "backward"
+		}
+		infoLog("Starting bounded %s %s crawl at %q", direction, kind, cursor)
+		s.report.startPass(kind, "", startGid)
+		crawlErr = errors.Join(crawlErr, s.crawl(p, cursor, s.bounds, nil))
+	}
+	return crawlErr
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCrawlRange(t *testing.T) {
+	day := func(s string) int64 {
+		d, _ := time.Parse("2006-01-02", s)
+		return d.Unix()
+	}
+	tests := []struct {
+		from, to string
+		backward bool
+		want     crawlRange
+	}{
+		{"", "", false, crawlRange{}},
+		{"", "", true, crawlRange{backward: true}},
+		{"100", "200", false, crawlRange{fromGid: 100, toGid: 200}},
+		{"100", "100", false, crawlRange{fromGid: 100, toGid: 100}},
+		{"", "200", true, crawlRange{toGid: 200, backward: true}},
+		{"2024-03-01", "2024-04-01", false, crawlRange{
+			fromPosted: day("2024-03-01"), toPosted: day("2024-04-01"),
+			fromDate: "2024-03-01", toDate: "2024-04-01",
+		}},
+		{"100", "2024-04-01", false, crawlRange{fromGid: 100, toPosted: day("2024-04-01"), toDate: "2024-04-01"}},
+	}
+	for _, tt := range tests {
+		got, err := parseCrawlRange(tt.from, tt.to, tt.backward)
+		if err != nil {
+			t.Errorf("parseCrawlRange(%q, %q): %v", tt.from, tt.to, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("parseCrawlRange(%q, %q) = %+v, want %+v", tt.from, tt.to, *got, tt.want)
+		}
+	}
+}
+
+func TestParseCrawlRangeErrors(t *testing.T) {
+	tests := []struct{ from, to string }{
+		{"0", ""},
+		{"-5", ""},
+		{"yesterday", ""},
+		{"", "2024-13-01"},
+		{"200", "100"},
+		{"2024-03-01", "2024-03-01"},
+		{"2024-04-01", "2024-03-01"},
+	}
+	for _, tt := range tests {
+		if _, err := parseCrawlRange(tt.from, tt.to, false); err == nil {
+			t.Errorf("parseCrawlRange(%q, %q): expected an error", tt.from, tt.to)
+		}
+	}
+}
+
+func TestCrawlRangePosition(t *testing.T) {
+	byGid, _ := parseCrawlRange("100", "200", false)
+	byDate, _ := parseCrawlRange("2024-03-01", "2024-04-01", false)
+	tests := []struct {
+		r     *crawlRange
+		entry PageEntry
+		want  int
+	}{
+		{byGid, PageEntry{GID: "99"}, -1},
+		{byGid, PageEntry{GID: "100"}, 0},
+		{byGid, PageEntry{GID: "200"}, 0},
+		{byGid, PageEntry{GID: "201"}, 1},
+		{byDate, PageEntry{GID: "1", Posted: "2024-02-29 23:59"}, -1},
+		{byDate, PageEntry{GID: "1", Posted: "2024-03-01 00:00"}, 0},
+		{byDate, PageEntry{GID: "1", Posted: "2024-03-31 23:59"}, 0},
+		{byDate, PageEntry{GID: "1", Posted: "2024-04-01 00:00"}, 1},
+		// Without a readable date only the gid counts.
+		{byDate, PageEntry{GID: "1"}, 0},
+		{byGid, PageEntry{GID: "150", Posted: "2000-01-01 00:00"}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.r.position(tt.entry); got != tt.want {
+			t.Errorf("position(%+v) = %d, want %d", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestCrawlRangeFilter(t *testing.T) {
+	page := func(gids ...string) []PageEntry {
+		var entries []PageEntry
+		for _, gid := range gids {
+			entries = append(entries, PageEntry{GID: gid})
+		}
+		return entries
+	}
+	forward, _ := parseCrawlRange("100", "200", false)
+	backward, _ := parseCrawlRange("100", "200", true)
+	tests := []struct {
+		name    string
+		r       *crawlRange
+		entries []PageEntry
+		want    []PageEntry
+		done    bool
+	}{
+		{"forward inside", forward, page("150", "140"), page("150", "140"), false},
+		{"forward past the end", forward, page("210", "200", "190"), page("200", "190"), true},
+		{"forward before the start", forward, page("110", "100", "90"), page("110", "100"), false},
+		{"backward inside", backward, page("150", "140"), page("150", "140"), false},
+		{"backward past the end", backward, page("110", "100", "90"), page("110", "100"), true},
+		{"backward above the range", backward, page("220", "210"), nil, false},
+		{"empty page", forward, nil, nil, true},
+	}
+	for _, tt := range tests {
+		got, done := tt.r.filter(tt.entries)
+		if !reflect.DeepEqual(got, tt.want) || done != tt.done {
+			t.Errorf("%s: filter = %v, %t, want %v, %t", tt.name, got, done, tt.want, tt.done)
+		}
+	}
+}
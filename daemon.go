@@ -0,0 +1,607 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+)
+
+// --- Schedules ---
+
+// schedule yields the next time a job is due after a given time, or the zero
+// time if it never is.
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// everySchedule fires at a fixed interval after the previous run.
+type everySchedule time.Duration
+
+func (e everySchedule) next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// cronSchedule is a standard five-field cron expression evaluated in local time.
+// Each field is a bit set of the allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parseSchedule parses a cron expression ("0 */6 * * *"), a macro such as
+// "@daily" or "@every 90m".
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("invalid schedule %q: @every needs a duration of at least 1m", spec)
+		}
+		return everySchedule(d), nil
+	}
+	if expr, ok := cronMacros[spec]; ok {
+		spec = expr
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields (minute hour day month weekday)", spec)
+	}
+	c := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: minute: %w", spec, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: hour: %w", spec, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of month: %w", spec, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: month: %w", spec, err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: weekday: %w", spec, err)
+	}
+	// 7 is another name for Sunday.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: never fires", spec)
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges (a-b), steps
+// (*/n, a-b/n, a/n) and names into a bit set.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not a value between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			var err error
+			if lo, err = value(part); err != nil {
+				return 0, err
+			}
+			if step == 1 {
+				hi = lo
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	// Like cron, a restricted day of month and weekday match either.
+	if !c.domAny && !c.dowAny {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// --- Job State ---
+
+// syncJobDDL creates sync_job, which keeps the last run of every daemon job and
+// the lock that stops two daemons from running the same job at once.
+var syncJobDDL = map[string][]string{
+	"mysql": {
+		"CREATE TABLE IF NOT EXISTS `sync_job` (" +
+			"`name` varchar(32) NOT NULL, " +
+			"`last_start` int(11) DEFAULT NULL, " +
+			"`last_end` int(11) DEFAULT NULL, " +
+			"`last_status` varchar(16) DEFAULT NULL, " +
+			"`last_error` text, " +
+			"`runs` int(11) NOT NULL DEFAULT 0, " +
+			"`failures` int(11) NOT NULL DEFAULT 0, " +
+			"`locked_by` varchar(100) DEFAULT NULL, " +
+			"`locked_at` int(11) DEFAULT NULL, " +
+			"PRIMARY KEY (`name`)" +
+			") ENGINE=MyISAM DEFAULT CHARSET=utf8mb4",
+	},
+	"sqlite3": {
+		"CREATE TABLE IF NOT EXISTS sync_job (name TEXT PRIMARY KEY, last_start INTEGER, last_end INTEGER, last_status TEXT, last_error TEXT, runs INTEGER NOT NULL DEFAULT 0, failures INTEGER NOT NULL DEFAULT 0, locked_by TEXT, locked_at INTEGER)",
+	},
+}
+
+// JobState is the stored state of a daemon job.
+type JobState struct {
+	Name       string
+	LastStart  int64
+	LastEnd    int64
+	LastStatus string
+	LastError  string
+	Runs       int
+	Failures   int
+	LockedBy   string
+	LockedAt   int64
+}
+
+const selectJobState = "SELECT name, COALESCE(last_start, 0), COALESCE(last_end, 0), COALESCE(last_status, ''), COALESCE(last_error, ''), runs, failures, COALESCE(locked_by, ''), COALESCE(locked_at, 0) FROM sync_job"
+
+func scanJobState(row interface{ Scan(...interface{}) error }) (JobState, error) {
+	var j JobState
+	err := row.Scan(&j.Name, &j.LastStart, &j.LastEnd, &j.LastStatus, &j.LastError, &j.Runs, &j.Failures, &j.LockedBy, &j.LockedAt)
+	return j, err
+}
+
+// jobState returns the stored state of a job; a job that never ran has a zero state.
+func (st *Store) jobState(name string) (JobState, error) {
+	j, err := scanJobState(st.db.QueryRow(selectJobState+" WHERE name = ?", name))
+	if err == sql.ErrNoRows {
+		return JobState{Name: name}, nil
+	}
+	if err != nil {
+		return j, fmt.Errorf("querying state of job %s: %w", name, err)
+	}
+	return j, nil
+}
+
+func (st *Store) jobStates() ([]JobState, error) {
+	rows, err := st.db.Query(selectJobState + " ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("querying job states: %w", err)
+	}
+	defer rows.Close()
+	var list []JobState
+	for rows.Next() {
+		j, err := scanJobState(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning job state: %w", err)
+		}
+		list = append(list, j)
+	}
+	return list, rows.Err()
+}
+
+// lockJob marks a job as running by owner and records its start. It fails to
+// take the lock (returning false) while another owner holds it, unless that
+// lock is older than staleBefore.
+func (st *Store) lockJob(name, owner string, now, staleBefore int64) (bool, error) {
+	if _, err := st.db.Exec("INSERT INTO sync_job (name) VALUES (?)", name); err != nil && !isDuplicateErr(err) {
+		return false, fmt.Errorf("creating state of job %s: %w", name, err)
+	}
+	res, err := st.db.Exec("UPDATE sync_job SET locked_by = ?, locked_at = ?, last_start = ? WHERE name = ? AND (locked_by IS NULL OR locked_at < ?)",
+		owner, now, now, name, staleBefore)
+	if err != nil {
+		return false, fmt.Errorf("locking job %s: %w", name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("locking job %s: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// finishJob records the outcome of a run and releases the lock.
+func (st *Store) finishJob(name string, end int64, runErr error) error {
+	status, msg, failed := "ok", "", 0
+	if runErr != nil {
+		status, msg, failed = "failed", runErr.Error(), 1
+	}
+	_, err := st.db.Exec("UPDATE sync_job SET last_end = ?, last_status = ?, last_error = ?, runs = runs + 1, failures = failures + ?, locked_by = NULL, locked_at = NULL WHERE name = ?",
+		end, status, msg, failed, name)
+	if err != nil {
+		return fmt.Errorf("saving state of job %s: %w", name, err)
+	}
+	return nil
+}
+
+// --- Daemon ---
+
+// daemonJobNames are the passes the daemon can schedule, in the order they run
+// when due at the same time.
+var daemonJobNames = []string{"normal", "expunged", "refresh"}
+
+// daemonJob is a scheduled pass: normal fetches new galleries, expunged fetches
+// expunged ones, and refresh re-crawls a longer window to pick up changed
+// ratings, tags and expunged flags of recent galleries.
+type daemonJob struct {
+	name     string
+	spec     string
+	schedule schedule
+	offset   int64 // hours, like --offset
+	profiles []*CrawlProfile
+	next     time.Time
+}
+
+func loadDaemonJobs(only []string) ([]*daemonJob, error) {
+	viper.SetDefault("daemon.jobs.normal.schedule", "0 * * * *")
+	viper.SetDefault("daemon.jobs.normal.offset", 24)
+	viper.SetDefault("daemon.jobs.expunged.schedule", "30 3 * * *")
+	viper.SetDefault("daemon.jobs.expunged.offset", 24)
+	viper.SetDefault("daemon.jobs.refresh.schedule", "0 4 * * 0")
+	viper.SetDefault("daemon.jobs.refresh.offset", 24*30)
+
+	var jobs []*daemonJob
+	for _, name := range daemonJobNames {
+		if len(only) > 0 && !containsString(only, name) {
+			continue
+		}
+		spec := viper.GetString("daemon.jobs." + name + ".schedule")
+		if spec == "" || spec == "off" {
+			continue
+		}
+		sched, err := parseSchedule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", name, err)
+		}
+		job := &daemonJob{name: name, spec: spec, schedule: sched, offset: viper.GetInt64("daemon.jobs." + name + ".offset")}
+		if names := viper.GetStringSlice("daemon.jobs." + name + ".profiles"); len(names) > 0 {
+			if job.profiles, err = loadCrawlProfiles(names); err != nil {
+				return nil, fmt.Errorf("job %s: %w", name, err)
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	for _, name := range only {
+		if !containsString(daemonJobNames, name) {
+			return nil, fmt.Errorf("unknown job %q (expected one of %s)", name, strings.Join(daemonJobNames, ", "))
+		}
+	}
+	return jobs, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// daemonSettings are the options of a daemon that apply to every job.
+type daemonSettings struct {
+	owner       string        // identifies this daemon in job locks
+	runNow      bool          // run every job once at startup
+	lockTimeout time.Duration // age after which another daemon's lock is ignored
+	reportDir   string        // write a JSON report of every run here
+}
+
+// passFor returns a copy of the sync configured for one run of job.
+func (s *Sync) passFor(job *daemonJob) *Sync {
+	pass := *s
+	pass.offset = job.offset
+	pass.onlyExpunged = job.name == "expunged"
+	pass.alsoExpunged = false
+	pass.profiles = job.profiles
+	return &pass
+}
+
+// runJob runs one pass of job unless another daemon holds its lock.
+func (s *Sync) runJob(job *daemonJob, cfg daemonSettings) {
+	now := time.Now()
+	locked, err := s.lockJob(job.name, cfg.owner, now.Unix(), now.Add(-cfg.lockTimeout).Unix())
+	if err != nil {
+		logFields{"job", job.name}.errorLog("Job %s: %v", job.name, err)
+		return
+	}
+	if !locked {
+		logFields{"job", job.name}.warnLog("Job %s is still running elsewhere, skipping this run", job.name)
+		return
+	}
+
+	if len(job.profiles) > 0 {
+		logFields{"job", job.name}.infoLog("Starting %s pass (%d profiles)", job.name, len(job.profiles))
+	} else {
+		logFields{"job", job.name}.infoLog("Starting %s pass (offset %dh)", job.name, job.offset)
+	}
+	s.metrics.jobStarted(job.name)
+	pass := s.passFor(job)
+	if cfg.reportDir != "" {
+		pass.report = NewRunReport(s.host, job.name)
+	}
+	runErr := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return pass.run()
+	}()
+	s.metrics.jobFinished(job.name, time.Since(now), runErr)
+	if runErr != nil {
+		logFields{"job", job.name}.errorLog("Job %s failed: %v", job.name, runErr)
+	} else {
+		s.metrics.syncSucceeded()
+		logFields{"job", job.name}.infoLog("Finished %s pass in %s", job.name, time.Since(now).Round(time.Second))
+	}
+	if err := s.finishJob(job.name, time.Now().Unix(), runErr); err != nil {
+		logFields{"job", job.name}.errorLog("Job %s: %v", job.name, err)
+	}
+	if pass.report != nil {
+		sum, err := s.databaseSummary()
+		if err != nil {
+			warnLog("Report: %v", err)
+		}
+		pass.report.finish(runErr, sum)
+		if err := pass.report.writeToDir(cfg.reportDir); err != nil {
+			logFields{"job", job.name}.errorLog("Job %s: %v", job.name, err)
+		}
+	}
+}
+
+// runDaemonLoop runs the jobs on their schedules, one at a time, until ctx is
+// done. A job that comes due while another one runs starts after it; a job
+// that missed runs while the daemon was down runs once right away.
+func (s *Sync) runDaemonLoop(ctx context.Context, stop context.CancelFunc, jobs []*daemonJob, cfg daemonSettings) error {
+	now := time.Now()
+	for _, job := range jobs {
+		state, err := s.jobState(job.name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case cfg.runNow:
+			job.next = now
+		case state.LastStart > 0:
+			job.next = job.schedule.next(time.Unix(state.LastStart, 0))
+			if job.next.Before(now) {
+				infoLog("Job %s missed a run while the daemon was stopped, running it now", job.name)
+				job.next = now
+			}
+		default:
+			job.next = job.schedule.next(now)
+		}
+		infoLog("Job %s (%s): next run %s", job.name, job.spec, job.next.Format("2006-01-02 15:04"))
+	}
+
+	for {
+		var due *daemonJob
+		for _, job := range jobs {
+			if due == nil || job.next.Before(due.next) {
+				due = job
+			}
+		}
+		timer := time.NewTimer(time.Until(due.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.runJob(due, cfg)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// Restore the default signal handling so a second interrupt aborts.
+			stop()
+			warnLog("Waiting for the %s pass to finish (interrupt again to abort)", due.name)
+			<-done
+			return nil
+		}
+		due.next = due.schedule.next(time.Now())
+		debugLog("Job %s: next run %s", due.name, due.next.Format("2006-01-02 15:04"))
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+func printJobStates(st *Store) error {
+	ok, err := st.hasTable("sync_job")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		infoLog("No job has run yet")
+		return nil
+	}
+	states, err := st.jobStates()
+	if err != nil {
+		return err
+	}
+	if len(states) == 0 {
+		infoLog("No job has run yet")
+		return nil
+	}
+	format := func(ts int64) string {
+		if ts == 0 {
+			return "-"
+		}
+		return time.Unix(ts, 0).Format("2006-01-02 15:04")
+	}
+	data := pterm.TableData{{"Job", "Last start", "Last end", "Status", "Runs", "Failures", "Running on", "Error"}}
+	for _, j := range states {
+		data = append(data, []string{j.Name, format(j.LastStart), format(j.LastEnd), j.LastStatus,
+			strconv.Itoa(j.Runs), strconv.Itoa(j.Failures), j.LockedBy, j.LastError})
+	}
+	return pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+func runDaemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	site := fs.String("site", "e-hentai", "Target site: 'e-hentai' or 'exhentai'")
+	cookieFile := fs.String("cookie-file", "", "Path to cookie JSON file (required for exhentai)")
+	jobList := fs.String("jobs", "", "Only schedule these jobs (comma separated: normal, expunged, refresh)")
+	runNow := fs.Bool("run-now", false, "Run every scheduled job once at startup")
+	status := fs.Bool("status", false, "Print the state of the jobs and exit")
+	history := fs.Bool("history", false, "Record field-level gallery changes in the gallery_history table")
+	scrapeTorrents := fs.Bool("scrape-torrents", false, "Also scrape torrent pages of imported galleries")
+	enrich := fs.Bool("enrich", false, "Also fetch gallery pages of imported galleries")
+	metricsListen := fs.String("metrics-listen", "", "Serve Prometheus metrics on this address (e.g. :9090) at /metrics")
+	reportDir := fs.String("report-dir", "", "Write a JSON report of every job run to this directory")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	if *status {
+		if err := printJobStates(openReadOnlyStore()); err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *history {
+		viper.Set("history", true)
+	}
+	var only []string
+	for _, name := range strings.Split(*jobList, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			only = append(only, name)
+		}
+	}
+	// Read the config file before the job settings.
+	loadConfig()
+	jobs, err := loadDaemonJobs(only)
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	if len(jobs) == 0 {
+		errorLog("No jobs are scheduled")
+		os.Exit(1)
+	}
+	viper.SetDefault("daemon.lock_timeout", "24h")
+	cfg := daemonSettings{
+		owner:       fmt.Sprintf("%s:%d", hostname(), os.Getpid()),
+		runNow:      *runNow,
+		lockTimeout: viper.GetDuration("daemon.lock_timeout"),
+		reportDir:   *reportDir,
+	}
+
+	s := NewSync(Options{Site: *site, CookieFile: *cookieFile, ScrapeTorrents: *scrapeTorrents, Enrich: *enrich, Metrics: NewMetrics()})
+	if err := s.ensureTables(syncJobDDL); err != nil {
+		errorLog("Error preparing job table: %v", err)
+		os.Exit(1)
+	}
+	s.initNewestPosted()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *metricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", s.metrics)
+		srv := &http.Server{Addr: *metricsListen, Handler: mux}
+		go func() {
+			infoLog("Serving metrics on http://%s/metrics", *metricsListen)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errorLog("Error serving metrics: %v", err)
+			}
+		}()
+		defer srv.Close()
+	}
+	err = s.runDaemonLoop(ctx, stop, jobs, cfg)
+	s.closeWatches()
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	infoLog("Daemon stopped")
+}
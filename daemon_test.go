@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// A Friday.
+	after := time.Date(2024, 3, 1, 10, 17, 42, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 */6 * * *", at(3, 1, 12, 0)},
+		{"*/15 10 * * *", at(3, 1, 10, 30)},
+		{"17 10 * * *", at(3, 2, 10, 17)},
+		{"@hourly", at(3, 1, 11, 0)},
+		{"@daily", at(3, 2, 0, 0)},
+		{"@weekly", at(3, 3, 0, 0)},
+		{"@monthly", at(4, 1, 0, 0)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * mon", at(3, 4, 9, 30)},
+		{"0 0 * * 7", at(3, 3, 0, 0)},
+		{"0 12 * feb-apr sat", at(3, 2, 12, 0)},
+		{"0 0 15 * *", at(3, 15, 0, 0)},
+		// A restricted day of month and weekday match either: Monday the 4th
+		// comes before the 15th.
+		{"0 0 15 * 1", at(3, 4, 0, 0)},
+		{"0 0 15 * sun", at(3, 3, 0, 0)},
+		{"0 0 2 * mon", at(3, 2, 0, 0)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5,10 8-9 1 jan *", time.Date(2025, 1, 1, 8, 5, 0, 0, time.UTC)},
+		{"0 1-23/10 * * *", at(3, 1, 11, 0)},
+		{"@every 90m", after.Add(90 * time.Minute)},
+		{" @every 1h ", after.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		sched, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := sched.next(after); !got.Equal(tt.want) {
+			t.Errorf("parseSchedule(%q).next = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"0 0 * * funday",
+		"0 0 31 2 *",
+		"@every 30s",
+		"@every soon",
+		"@sometimes",
+	} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q): expected an error", spec)
+		}
+	}
+}
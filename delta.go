@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Change Log ---
+
+// changeLogDDL creates gallery_change, which records a monotonically increasing
+// sequence number every time a gallery (or its tags and torrents) changes.
+var changeLogDDL = map[string][]string{
+	"mysql": {
+		"CREATE TABLE IF NOT EXISTS `gallery_change` (" +
+			"`seq` bigint(20) NOT NULL AUTO_INCREMENT, " +
+			"`gid` int(11) NOT NULL, " +
+			"`changed_at` int(11) NOT NULL, " +
+			"PRIMARY KEY (`seq`), KEY `gid` (`gid`)" +
+			") ENGINE=MyISAM DEFAULT CHARSET=utf8mb4",
+	},
+	"sqlite3": {
+		"CREATE TABLE IF NOT EXISTS gallery_change (seq INTEGER PRIMARY KEY AUTOINCREMENT, gid INTEGER NOT NULL, changed_at INTEGER NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS gallery_change_gid ON gallery_change (gid)",
+	},
+}
+
+func (st *Store) recordChange(gid int) error {
+	_, err := st.db.Exec("INSERT INTO gallery_change (gid, changed_at) VALUES (?, ?)", gid, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("inserting change for gid %d: %w", gid, err)
+	}
+	return nil
+}
+
+type fieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// galleryFieldChanges compares a stored gallery with fresh API metadata.
+// archiver_key is left out on purpose: it is regenerated on every API call.
+func galleryFieldChanges(old *GalleryRecord, g GalleryMetadata) []fieldChange {
+	var changes []fieldChange
+	compare := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, fieldChange{Field: field, Old: before, New: after})
+		}
+	}
+	rootGid := func(v string) string {
+		if v == "" || v == "0" {
+			return ""
+		}
+		return v
+	}
+	compare("token", old.Token, g.Token)
+	compare("title", old.Title, g.Title)
+	compare("title_jpn", old.TitleJpn, g.TitleJpn)
+	compare("category", old.Category, g.Category)
+	compare("thumb", old.Thumb, g.Thumb)
+	compare("uploader", old.Uploader, g.Uploader)
+	compare("posted", strconv.FormatInt(old.Posted, 10), strconv.FormatInt(g.Posted, 10))
+	compare("filecount", strconv.Itoa(old.Filecount), strconv.Itoa(g.Filecount))
+	compare("filesize", strconv.Itoa(old.Filesize), strconv.Itoa(g.Filesize))
+	compare("expunged", strconv.FormatBool(old.Expunged), strconv.FormatBool(g.Expunged))
+	compare("rating", old.Rating.String(), g.Rating.String())
+	compare("torrentcount", strconv.Itoa(old.Torrentcount), strconv.Itoa(g.Torrentcount))
+	compare("root_gid", rootGid(old.ParentGid), rootGid(g.ParentGid))
+	return changes
+}
+
+// --- Delta Export ---
+
+// DeltaEntry is one line of a delta export. Upserts carry the complete gallery
+// record; deletes only the gid.
+type DeltaEntry struct {
+	Op      string         `json:"op"` // "upsert" or "delete"
+	Seq     int64          `json:"seq"`
+	Gid     int            `json:"gid"`
+	Gallery *GalleryRecord `json:"gallery,omitempty"`
+}
+
+// exportDelta writes every gallery changed after the since checkpoint and
+// returns the checkpoint to pass to the next export.
+func (st *Store) exportDelta(w io.Writer, since int64) (int, int64, error) {
+	var maxSeq sql.NullInt64
+	if err := st.db.QueryRow("SELECT MAX(seq) FROM gallery_change").Scan(&maxSeq); err != nil {
+		return 0, since, fmt.Errorf("querying change log: %w", err)
+	}
+	if !maxSeq.Valid || maxSeq.Int64 <= since {
+		return 0, since, nil
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	count := 0
+	after := 0
+	for {
+		rows, err := st.db.Query("SELECT gid, MAX(seq) FROM gallery_change WHERE seq > ? AND seq <= ? AND gid > ? GROUP BY gid ORDER BY gid LIMIT ?",
+			since, maxSeq.Int64, after, exportChunkSize)
+		if err != nil {
+			return count, since, fmt.Errorf("querying change log: %w", err)
+		}
+		var gids []int
+		seqs := make(map[int]int64)
+		for rows.Next() {
+			var gid int
+			var seq int64
+			if err := rows.Scan(&gid, &seq); err != nil {
+				rows.Close()
+				return count, since, fmt.Errorf("scanning change log: %w", err)
+			}
+			gids = append(gids, gid)
+			seqs[gid] = seq
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return count, since, fmt.Errorf("reading change log: %w", err)
+		}
+		if len(gids) == 0 {
+			break
+		}
+
+		records, err := st.loadGalleryRecords(gids)
+		if err != nil {
+			return count, since, err
+		}
+		for _, gid := range gids {
+			entry := DeltaEntry{Op: "upsert", Seq: seqs[gid], Gid: gid, Gallery: records[gid]}
+			if entry.Gallery == nil {
+				entry.Op = "delete"
+			}
+			if err := enc.Encode(entry); err != nil {
+				return count, since, err
+			}
+			count++
+		}
+		after = gids[len(gids)-1]
+		if len(gids) < exportChunkSize {
+			break
+		}
+	}
+	return count, maxSeq.Int64, bw.Flush()
+}
+
+func readCheckpoint(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	seq, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint in %s: %w", path, err)
+	}
+	return seq, nil
+}
+
+// runDeltaExport handles `export --since` / `export --checkpoint`.
+func runDeltaExport(output string, since int64, checkpointFile string) {
+	if since < 0 {
+		var err error
+		since, err = readCheckpoint(checkpointFile)
+		if err != nil {
+			errorLog("Error reading checkpoint: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			errorLog("Error creating output file: %v", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	st := openReadOnlyStore()
+	if !st.hasChangeLog {
+		errorLog("The database has no gallery_change table; run sync once to migrate the database")
+		os.Exit(1)
+	}
+	count, checkpoint, err := st.exportDelta(w, since)
+	if err != nil {
+		errorLog("Error exporting delta: %v", err)
+		os.Exit(1)
+	}
+	if checkpointFile != "" {
+		if err := os.WriteFile(checkpointFile, []byte(strconv.FormatInt(checkpoint, 10)+"\n"), 0o644); err != nil {
+			errorLog("Error writing checkpoint: %v", err)
+			os.Exit(1)
+		}
+	}
+	infoLog("Exported %d changed galleries since checkpoint %d (new checkpoint: %d)", count, since, checkpoint)
+}
+
+// --- Apply Delta ---
+
+// applyGalleryRecord replaces a gallery together with its tags, torrents and
+// page details.
+func (st *Store) applyGalleryRecord(r *GalleryRecord) error {
+	rootGid, _ := strconv.Atoi(r.ParentGid)
+	_, err := st.db.Exec(st.upsertSQL("gallery", "gid",
+		[]string{"gid", "token", "archiver_key", "title", "title_jpn", "category", "thumb", "uploader", "posted", "filecount", "filesize", "expunged", "removed", "replaced", "rating", "torrentcount", "root_gid"},
+		[]string{"token", "archiver_key", "title", "title_jpn", "category", "thumb", "uploader", "posted", "filecount", "filesize", "expunged", "removed", "replaced", "rating", "torrentcount", "root_gid"}),
+		r.Gid, r.Token, r.ArchiverKey, r.Title, r.TitleJpn, r.Category, r.Thumb, r.Uploader, r.Posted, r.Filecount, r.Filesize,
+		r.Expunged, r.Removed, r.Replaced, r.Rating.String(), r.Torrentcount, rootGid)
+	if err != nil {
+		return fmt.Errorf("upserting gallery gid %d: %w", r.Gid, err)
+	}
+
+	if _, err := st.db.Exec("DELETE FROM gid_tid WHERE gid = ?", r.Gid); err != nil {
+		return fmt.Errorf("clearing tags for gid %d: %w", r.Gid, err)
+	}
+	for _, name := range r.Tags {
+		tagID, err := st.tagID(name)
+		if err != nil {
+			return err
+		}
+		if _, err := st.db.Exec("INSERT INTO gid_tid (gid, tid) VALUES (?, ?)", r.Gid, tagID); err != nil && !isDuplicateErr(err) {
+			return fmt.Errorf("inserting gid_tid for gid %d and tag '%s': %w", r.Gid, name, err)
+		}
+	}
+
+	if _, err := st.db.Exec("DELETE FROM torrent WHERE gid = ?", r.Gid); err != nil {
+		return fmt.Errorf("clearing torrents for gid %d: %w", r.Gid, err)
+	}
+	scraped := len(r.Torrents) > 0 && len(r.Torrents) >= r.Torrentcount
+	for _, t := range r.Torrents {
+		scraped = scraped && t.ID > 0
+		uploader := t.Uploader
+		if uploader == "" {
+			uploader = r.Uploader
+		}
+		if err := st.saveTorrent(r.Gid, t, uploader); err != nil {
+			return err
+		}
+		if t.ID == 0 && t.Seeders == nil && t.Leechers == nil && t.Downloads == nil {
+			continue
+		}
+		if _, err := st.db.Exec("UPDATE torrent SET id = ?, seeders = ?, leechers = ?, downloads = ? WHERE gid = ? AND hash = ?",
+			t.ID, t.Seeders, t.Leechers, t.Downloads, r.Gid, t.Hash); err != nil {
+			return fmt.Errorf("updating torrent %s for gid %d: %w", t.Hash, r.Gid, err)
+		}
+	}
+	// Torrents scraped on the exporting side don't need scraping again here.
+	if _, err := st.db.Exec("UPDATE gallery SET bytorrent = ? WHERE gid = ?", scraped, r.Gid); err != nil {
+		return fmt.Errorf("updating bytorrent for gid %d: %w", r.Gid, err)
+	}
+
+	if r.Detail != nil {
+		if !st.hasGalleryDetail {
+			if err := st.ensureColumns("gallery", galleryDetailColumns); err != nil {
+				return err
+			}
+			st.hasGalleryDetail = true
+		}
+		return st.saveGalleryDetail(r.Gid, *r.Detail)
+	}
+	return nil
+}
+
+func (st *Store) deleteGallery(gid int) error {
+	for _, table := range []string{"gid_tid", "torrent", "gallery"} {
+		if _, err := st.db.Exec("DELETE FROM "+table+" WHERE gid = ?", gid); err != nil {
+			return fmt.Errorf("deleting gid %d from %s: %w", gid, table, err)
+		}
+	}
+	return nil
+}
+
+// applyDelta applies a delta export produced by exportDelta. Applied galleries are
+// recorded in the local change log so deltas can be chained across mirrors.
+func (st *Store) applyDelta(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	applied := 0
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry DeltaEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return applied, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch entry.Op {
+		case "upsert":
+			if entry.Gallery == nil {
+				return applied, fmt.Errorf("line %d: upsert without gallery", line)
+			}
+			if err := st.applyGalleryRecord(entry.Gallery); err != nil {
+				return applied, fmt.Errorf("line %d: %w", line, err)
+			}
+		case "delete":
+			if err := st.deleteGallery(entry.Gid); err != nil {
+				return applied, fmt.Errorf("line %d: %w", line, err)
+			}
+		default:
+			return applied, fmt.Errorf("line %d: unknown op %q", line, entry.Op)
+		}
+		if err := st.recordChange(entry.Gid); err != nil {
+			return applied, err
+		}
+		applied++
+		debugLog("Applied %s for gid %d", entry.Op, entry.Gid)
+	}
+	return applied, scanner.Err()
+}
+
+func runApplyDelta(args []string) {
+	fs := flag.NewFlagSet("apply-delta", flag.ExitOnError)
+	input := fs.String("input", "-", "Delta file produced by 'export --since' ('-' for stdin)")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			errorLog("Error opening delta file: %v", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	st := openStore(loadConfig())
+	if err := st.ensureSchema(); err != nil {
+		errorLog("Error preparing schema: %v", err)
+		os.Exit(1)
+	}
+	if err := st.setNames(); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	applied, err := st.applyDelta(r)
+	if err != nil {
+		errorLog("Error applying delta after %d entries: %v", applied, err)
+		os.Exit(1)
+	}
+	infoLog("Applied %d delta entries", applied)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Gallery Page Enrichment ---
+
+// GalleryDetail holds the fields of a gallery page (/g/<gid>/<token>/) that the
+// gdata API doesn't return.
+type GalleryDetail struct {
+	Language      string `json:"language"`   // e.g. "Japanese"; empty if not shown
+	Translated    bool   `json:"translated"` // the "TR" marker next to the language
+	Visible       bool   `json:"visible"`
+	VisibleReason string `json:"visible_reason,omitempty"` // why the gallery is hidden, e.g. "Expunged" or "Replaced"
+	Favorited     int    `json:"favorited"`
+	RatingCount   int    `json:"rating_count"`
+	FirstPage     string `json:"-"`                    // URL of the first image page
+	PageWidth     int    `json:"page_width,omitempty"` // dimensions of the first page; 0 unless fetched
+	PageHeight    int    `json:"page_height,omitempty"`
+	Fetched       int64  `json:"fetched"` // unix time of the fetch; saveGalleryDetail uses now when 0
+}
+
+// galleryDetailColumns are filled by the enrichment stage. detail_fetched is the
+// unix time of the last successful fetch and stays NULL for galleries never enriched.
+var galleryDetailColumns = []columnDef{
+	{"language", "VARCHAR(32) DEFAULT NULL"},
+	{"translated", "TINYINT(1) DEFAULT NULL"},
+	{"visible", "TINYINT(1) DEFAULT NULL"},
+	{"visible_reason", "VARCHAR(32) DEFAULT NULL"},
+	{"favorited", "INT DEFAULT NULL"},
+	{"rating_count", "INT DEFAULT NULL"},
+	{"page_width", "INT DEFAULT NULL"},
+	{"page_height", "INT DEFAULT NULL"},
+	{"detail_fetched", "INT DEFAULT NULL"},
+}
+
+var (
+	detailRowRe         = regexp.MustCompile(`<td class="gdt1">([^<]+?):?</td>\s*<td class="gdt2"[^>]*>(.*?)</td>`)
+	detailRatingCountRe = regexp.MustCompile(`id="rating_count"[^>]*>\s*([\d,]+)`)
+	detailFirstPageRe   = regexp.MustCompile(`href="(https?://[^"]+/s/[0-9a-f]+/\d+-1)"`)
+	detailVisibleRe     = regexp.MustCompile(`^No\s*\(([^)]+)\)`)
+	detailCountRe       = regexp.MustCompile(`^([\d,]+)`)
+	imageDimensionsRe   = regexp.MustCompile(`::\s*(\d+)\s*x\s*(\d+)\s*::`)
+	htmlTagRe           = regexp.MustCompile(`<[^>]*>`)
+)
+
+var errGalleryUnavailable = errors.New("gallery page not available")
+
+// parseGalleryPage extracts the gallery details from a /g/ page.
+func parseGalleryPage(body string) (GalleryDetail, error) {
+	rows := detailRowRe.FindAllStringSubmatch(body, -1)
+	if len(rows) == 0 {
+		return GalleryDetail{}, errGalleryUnavailable
+	}
+	d := GalleryDetail{Visible: true}
+	for _, row := range rows {
+		raw := row[2]
+		value := strings.TrimSpace(html.UnescapeString(htmlTagRe.ReplaceAllString(raw, " ")))
+		switch strings.TrimSpace(row[1]) {
+		case "Language":
+			d.Translated = strings.Contains(raw, ">TR<")
+			if fields := strings.Fields(value); len(fields) > 0 {
+				d.Language = fields[0]
+			}
+		case "Visible":
+			if m := detailVisibleRe.FindStringSubmatch(value); m != nil {
+				d.Visible = false
+				d.VisibleReason = m[1]
+			} else {
+				d.Visible = value == "Yes"
+			}
+		case "Favorited":
+			switch value {
+			case "Never":
+				d.Favorited = 0
+			case "Once":
+				d.Favorited = 1
+			default:
+				if m := detailCountRe.FindStringSubmatch(value); m != nil {
+					d.Favorited, _ = strconv.Atoi(strings.ReplaceAll(m[1], ",", ""))
+				}
+			}
+		}
+	}
+	if m := detailRatingCountRe.FindStringSubmatch(body); m != nil {
+		d.RatingCount, _ = strconv.Atoi(strings.ReplaceAll(m[1], ",", ""))
+	}
+	if m := detailFirstPageRe.FindStringSubmatch(body); m != nil {
+		d.FirstPage = m[1]
+	}
+	return d, nil
+}
+
+// parseImageDimensions extracts the "<file> :: W x H :: <size>" line of an image page.
+func parseImageDimensions(body string) (int, int, bool) {
+	m := imageDimensionsRe.FindStringSubmatch(body)
+	if m == nil {
+		return 0, 0, false
+	}
+	w, _ := strconv.Atoi(m[1])
+	h, _ := strconv.Atoi(m[2])
+	return w, h, true
+}
+
+// saveGalleryDetail writes the enrichment columns of a gallery. Page dimensions
+// are only overwritten when they were fetched.
+func (st *Store) saveGalleryDetail(gid int, d GalleryDetail) error {
+	if d.Fetched == 0 {
+		d.Fetched = time.Now().Unix()
+	}
+	sets := "language = ?, translated = ?, visible = ?, visible_reason = ?, favorited = ?, rating_count = ?, detail_fetched = ?"
+	args := []interface{}{d.Language, d.Translated, d.Visible, d.VisibleReason, d.Favorited, d.RatingCount, d.Fetched}
+	if d.PageWidth > 0 {
+		sets += ", page_width = ?, page_height = ?"
+		args = append(args, d.PageWidth, d.PageHeight)
+	}
+	args = append(args, gid)
+	if _, err := st.db.Exec("UPDATE gallery SET "+sets+" WHERE gid = ?", args...); err != nil {
+		return fmt.Errorf("updating details for gid %d: %w", gid, err)
+	}
+	return nil
+}
+
+// galleryDetail reads the stored enrichment columns of a gallery. Fetched is 0
+// for galleries never enriched.
+func (st *Store) galleryDetail(gid int) (GalleryDetail, error) {
+	var d GalleryDetail
+	err := st.db.QueryRow("SELECT COALESCE(language, ''), COALESCE(translated, 0), COALESCE(visible, 0), COALESCE(visible_reason, ''), "+
+		"COALESCE(favorited, 0), COALESCE(rating_count, 0), COALESCE(page_width, 0), COALESCE(page_height, 0), COALESCE(detail_fetched, 0) "+
+		"FROM gallery WHERE gid = ?", gid).
+		Scan(&d.Language, &d.Translated, &d.Visible, &d.VisibleReason, &d.Favorited, &d.RatingCount, &d.PageWidth, &d.PageHeight, &d.Fetched)
+	if err != nil {
+		return GalleryDetail{}, fmt.Errorf("querying details for gid %d: %w", gid, err)
+	}
+	return d, nil
+}
+
+// detailChanged tells whether saving fresh over stored changes anything besides
+// the fetch time. Page dimensions only count when they were fetched.
+func detailChanged(stored, fresh GalleryDetail) bool {
+	if stored.Fetched == 0 {
+		return true
+	}
+	if fresh.PageWidth == 0 {
+		fresh.PageWidth, fresh.PageHeight = stored.PageWidth, stored.PageHeight
+	}
+	fresh.FirstPage, fresh.Fetched = stored.FirstPage, stored.Fetched
+	return fresh != stored
+}
+
+// attachDetails fills Detail of the enriched galleries in byGid.
+func (st *Store) attachDetails(byGid map[int]*GalleryRecord, gids []interface{}) error {
+	if !st.hasGalleryDetail {
+		return nil
+	}
+	rows, err := st.db.Query("SELECT gid, COALESCE(language, ''), COALESCE(translated, 0), COALESCE(visible, 0), COALESCE(visible_reason, ''), "+
+		"COALESCE(favorited, 0), COALESCE(rating_count, 0), COALESCE(page_width, 0), COALESCE(page_height, 0), detail_fetched "+
+		"FROM gallery WHERE gid IN ("+placeholders(len(gids))+") AND detail_fetched IS NOT NULL", gids...)
+	if err != nil {
+		return fmt.Errorf("querying gallery details: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var gid int
+		var d GalleryDetail
+		if err := rows.Scan(&gid, &d.Language, &d.Translated, &d.Visible, &d.VisibleReason, &d.Favorited, &d.RatingCount, &d.PageWidth, &d.PageHeight, &d.Fetched); err != nil {
+			return fmt.Errorf("scanning gallery details: %w", err)
+		}
+		byGid[gid].Detail = &d
+	}
+	return rows.Err()
+}
+
+// enrichGallery fetches the gallery page and, if dimensions is set, its first image
+// page, and stores the parsed details. nw=always skips the content warning.
+// Galleries whose details changed are recorded in the change log.
+func (s *Sync) enrichGallery(gid int, token string, dimensions bool) error {
+	fetchURL := fmt.Sprintf("https://%s/g/%d/%s/?nw=always", s.host, gid, token)
+	body, err := s.fetchPage(fetchURL)
+	if err != nil {
+		return fmt.Errorf("fetching gallery page for gid %d: %w", gid, err)
+	}
+	d, err := parseGalleryPage(body)
+	if err != nil {
+		return fmt.Errorf("gid %d: %w", gid, err)
+	}
+	if dimensions && d.FirstPage != "" {
+		time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
+		page, err := s.fetchPage(d.FirstPage)
+		if err != nil {
+			return fmt.Errorf("fetching first page for gid %d: %w", gid, err)
+		}
+		if w, h, ok := parseImageDimensions(page); ok {
+			d.PageWidth, d.PageHeight = w, h
+		} else {
+			warnLog("No page dimensions found on %s", d.FirstPage)
+		}
+	}
+	stored, err := s.galleryDetail(gid)
+	if err != nil {
+		return err
+	}
+	if err := s.saveGalleryDetail(gid, d); err != nil {
+		return err
+	}
+	if detailChanged(stored, d) {
+		if err := s.recordChange(gid); err != nil {
+			return err
+		}
+	}
+	debugLog("Enriched gid %d: language=%s favorited=%d rating_count=%d visible=%t", gid, d.Language, d.Favorited, d.RatingCount, d.Visible)
+	return nil
+}
+
+// enrichGalleries enriches freshly imported galleries.
+func (s *Sync) enrichGalleries(galleries []GalleryMetadata) {
+	for _, gallery := range galleries {
+		time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
+		if err := s.enrichGallery(gallery.Gid, gallery.Token, s.enrichDimensions); err != nil {
+			errorLog("Error enriching gallery: %v", err)
+		}
+	}
+}
+
+// enrichPendingGalleries walks stored galleries, newest first, and enriches those
+// never enriched or last enriched before olderThan ago (when olderThan > 0). With
+// all set every gallery is fetched again. A limit of 0 means no limit.
+func (s *Sync) enrichPendingGalleries(limit int, all bool, olderThan time.Duration, dimensions bool) error {
+	where := "1 = 1"
+	if !all {
+		where = "g.detail_fetched IS NULL"
+		if olderThan > 0 {
+			where += fmt.Sprintf(" OR g.detail_fetched < %d", time.Now().Add(-olderThan).Unix())
+		}
+	}
+	enriched := 0
+	err := s.walkGalleries(where, func(gid int, token string) (bool, error) {
+		if limit > 0 && enriched >= limit {
+			return false, nil
+		}
+		time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
+		if err := s.enrichGallery(gid, token, dimensions); err != nil {
+			errorLog("Error enriching gallery: %v", err)
+			return true, nil
+		}
+		enriched++
+		return true, nil
+	})
+	infoLog("Enriched %d galleries", enriched)
+	return err
+}
+
+func runEnrich(args []string) {
+	fs := flag.NewFlagSet("enrich", flag.ExitOnError)
+	site := fs.String("site", "e-hentai", "Target site: 'e-hentai' or 'exhentai'")
+	cookieFile := fs.String("cookie-file", "", "Path to cookie JSON file (required for exhentai)")
+	limit := fs.Int("limit", 0, "Maximum number of galleries to enrich (0 for no limit)")
+	all := fs.Bool("all", false, "Also re-fetch galleries that were already enriched")
+	olderThan := fs.Duration("older-than", 0, "Also re-fetch galleries enriched longer ago than this (e.g. 720h)")
+	dimensions := fs.Bool("dimensions", false, "Also fetch the first image page of each gallery for its page dimensions")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	s := NewSync(Options{Site: *site, CookieFile: *cookieFile, Enrich: true})
+	if err := s.setNames(); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	if err := s.enrichPendingGalleries(*limit, *all, *olderThan, *dimensions); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestDetailChanged(t *testing.T) {
+	stored := GalleryDetail{Language: "Japanese", Visible: true, Favorited: 10, RatingCount: 5, PageWidth: 1280, PageHeight: 1810, Fetched: 1000}
+	tests := []struct {
+		name   string
+		stored GalleryDetail
+		fresh  GalleryDetail
+		want   bool
+	}{
+		{"never enriched", GalleryDetail{}, GalleryDetail{Visible: true}, true},
+		{"same", stored, GalleryDetail{Language: "Japanese", Visible: true, Favorited: 10, RatingCount: 5, PageWidth: 1280, PageHeight: 1810, Fetched: 2000}, false},
+		{"dimensions not fetched", stored, GalleryDetail{Language: "Japanese", Visible: true, Favorited: 10, RatingCount: 5, FirstPage: "https://e-hentai.org/s/0123456789/1-1"}, false},
+		{"favorited", stored, GalleryDetail{Language: "Japanese", Visible: true, Favorited: 11, RatingCount: 5}, true},
+		{"hidden", stored, GalleryDetail{Language: "Japanese", VisibleReason: "Expunged", Favorited: 10, RatingCount: 5}, true},
+		{"dimensions", stored, GalleryDetail{Language: "Japanese", Visible: true, Favorited: 10, RatingCount: 5, PageWidth: 1280, PageHeight: 1800}, true},
+	}
+	for _, tt := range tests {
+		if got := detailChanged(tt.stored, tt.fresh); got != tt.want {
+			t.Errorf("%s: detailChanged = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
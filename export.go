@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Gallery Records ---
+
+// GalleryRecord is a self-contained gallery row with its tags and torrents
+// embedded, mirroring the shape of the API's GalleryMetadata.
+type GalleryRecord struct {
+	GalleryMetadata
+	Removed  bool `json:"removed"`
+	Replaced bool `json:"replaced"`
+	// TagTranslations maps tags to their translated names when an export asks for a language.
+	TagTranslations map[string]string `json:"tag_translations,omitempty"`
+	// Detail holds the gallery page fields of enriched galleries (see enrich).
+	Detail *GalleryDetail `json:"detail,omitempty"`
+}
+
+// ExportFilter narrows the set of galleries read from the store.
+// Zero values leave the corresponding bound open.
+type ExportFilter struct {
+	GidFrom    int64
+	GidTo      int64
+	PostedFrom int64 // unix seconds, inclusive
+	PostedTo   int64 // unix seconds, exclusive
+	Category   string
+	Tag        string
+	TagLang    string // not a filter: also load tag translations in this language
+}
+
+const galleryColumns = "gid, token, archiver_key, title, title_jpn, category, thumb, uploader, posted, filecount, filesize, expunged, removed, replaced, rating, torrentcount, root_gid"
+
+// exportChunkSize is the number of galleries loaded (with their tags and
+// torrents) per round trip while streaming.
+const exportChunkSize = 1000
+
+// where builds the WHERE clause (without the keyword) and its arguments.
+func (f ExportFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.GidFrom > 0 {
+		conds = append(conds, "gid >= ?")
+		args = append(args, f.GidFrom)
+	}
+	if f.GidTo > 0 {
+		conds = append(conds, "gid <= ?")
+		args = append(args, f.GidTo)
+	}
+	if f.PostedFrom > 0 {
+		conds = append(conds, "posted >= ?")
+		args = append(args, f.PostedFrom)
+	}
+	if f.PostedTo > 0 {
+		conds = append(conds, "posted < ?")
+		args = append(args, f.PostedTo)
+	}
+	if f.Category != "" {
+		conds = append(conds, "category = ?")
+		args = append(args, f.Category)
+	}
+	if f.Tag != "" {
+		namespace, value := splitTag(f.Tag)
+		conds = append(conds, "gid IN (SELECT gt.gid FROM gid_tid gt JOIN tag t ON t.id = gt.tid WHERE t.namespace = ? AND t.value = ?)")
+		args = append(args, namespace, value)
+	}
+	if len(conds) == 0 {
+		return "1=1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func scanGalleryRecord(rows *sql.Rows) (*GalleryRecord, error) {
+	var (
+		r        GalleryRecord
+		uploader sql.NullString
+		rootGid  sql.NullInt64
+	)
+	err := rows.Scan(&r.Gid, &r.Token, &r.ArchiverKey, &r.Title, &r.TitleJpn, &r.Category, &r.Thumb, &uploader,
+		&r.Posted, &r.Filecount, &r.Filesize, &r.Expunged, &r.Removed, &r.Replaced, (*float64)(&r.Rating), &r.Torrentcount, &rootGid)
+	if err != nil {
+		return nil, err
+	}
+	r.Uploader = uploader.String
+	if rootGid.Valid && rootGid.Int64 > 0 {
+		r.ParentGid = strconv.FormatInt(rootGid.Int64, 10)
+	}
+	r.Tags = []string{}
+	r.Torrents = []TorrentInfo{}
+	return &r, nil
+}
+
+// forEachGallery streams galleries matching the filter in gid order. Galleries are
+// read in keyset-paginated chunks so memory use stays flat on the full table.
+func (st *Store) forEachGallery(f ExportFilter, fn func(*GalleryRecord) error) error {
+	where, args := f.where()
+	query := "SELECT " + galleryColumns + " FROM gallery WHERE gid > ? AND " + where + " ORDER BY gid LIMIT ?"
+
+	var after int64
+	for {
+		rows, err := st.db.Query(query, append(append([]interface{}{after}, args...), exportChunkSize)...)
+		if err != nil {
+			return fmt.Errorf("querying galleries: %w", err)
+		}
+		var chunk []*GalleryRecord
+		for rows.Next() {
+			r, err := scanGalleryRecord(rows)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning gallery: %w", err)
+			}
+			chunk = append(chunk, r)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("reading galleries: %w", err)
+		}
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		if err := st.attachRelations(chunk); err != nil {
+			return err
+		}
+		if f.TagLang != "" {
+			if err := st.attachTagTranslations(chunk, f.TagLang); err != nil {
+				return err
+			}
+		}
+		for _, r := range chunk {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		after = int64(chunk[len(chunk)-1].Gid)
+		if len(chunk) < exportChunkSize {
+			return nil
+		}
+	}
+}
+
+// loadGalleryRecords loads the given galleries with their tags and torrents,
+// keyed by gid. Galleries missing from the store are absent from the map.
+func (st *Store) loadGalleryRecords(gids []int) (map[int]*GalleryRecord, error) {
+	records := make(map[int]*GalleryRecord, len(gids))
+	if len(gids) == 0 {
+		return records, nil
+	}
+	args := make([]interface{}, len(gids))
+	for i, gid := range gids {
+		args[i] = gid
+	}
+	rows, err := st.db.Query("SELECT "+galleryColumns+" FROM gallery WHERE gid IN ("+placeholders(len(gids))+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying galleries: %w", err)
+	}
+	var chunk []*GalleryRecord
+	for rows.Next() {
+		r, err := scanGalleryRecord(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning gallery: %w", err)
+		}
+		chunk = append(chunk, r)
+		records[r.Gid] = r
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading galleries: %w", err)
+	}
+	if len(chunk) == 0 {
+		return records, nil
+	}
+	if err := st.attachRelations(chunk); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// attachRelations loads tags, torrents and page details for a chunk of galleries.
+func (st *Store) attachRelations(chunk []*GalleryRecord) error {
+	byGid := make(map[int]*GalleryRecord, len(chunk))
+	gids := make([]interface{}, 0, len(chunk))
+	for _, r := range chunk {
+		byGid[r.Gid] = r
+		gids = append(gids, r.Gid)
+	}
+	in := placeholders(len(gids))
+
+	rows, err := st.db.Query("SELECT gt.gid, t.name FROM gid_tid gt JOIN tag t ON t.id = gt.tid WHERE gt.gid IN ("+in+") ORDER BY gt.gid, t.name", gids...)
+	if err != nil {
+		return fmt.Errorf("querying tags: %w", err)
+	}
+	for rows.Next() {
+		var gid int
+		var name string
+		if err := rows.Scan(&gid, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning tag: %w", err)
+		}
+		byGid[gid].Tags = append(byGid[gid].Tags, name)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("reading tags: %w", err)
+	}
+
+	rows, err = st.db.Query("SELECT "+st.torrentColumns()+" FROM torrent WHERE gid IN ("+in+") ORDER BY gid, added", gids...)
+	if err != nil {
+		return fmt.Errorf("querying torrents: %w", err)
+	}
+	for rows.Next() {
+		gid, t, err := scanTorrent(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning torrent: %w", err)
+		}
+		byGid[gid].Torrents = append(byGid[gid].Torrents, t)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("reading torrents: %w", err)
+	}
+	return st.attachDetails(byGid, gids)
+}
+
+// torrentColumns selects a stored torrent for scanTorrent. Columns an older
+// database lacks are read as NULL.
+func (st *Store) torrentColumns() string {
+	sizes, stats := "fsize, tsize", "seeders, leechers, downloads"
+	if !st.hasTorrentSizes {
+		sizes = "NULL, NULL"
+	}
+	if !st.hasTorrentStats {
+		stats = "NULL, NULL, NULL"
+	}
+	return "gid, COALESCE(id, 0), name, COALESCE(hash, ''), " + st.unixTimeExpr("added") + ", " + sizes + ", COALESCE(uploader, ''), " + stats
+}
+
+// scanTorrent reads a row selected with torrentColumns. A torrent without added
+// time keeps Added empty.
+func scanTorrent(rows *sql.Rows) (int, TorrentInfo, error) {
+	var gid int
+	var t TorrentInfo
+	var added, fsize, tsize, seeders, leechers, downloads sql.NullInt64
+	if err := rows.Scan(&gid, &t.ID, &t.Name, &t.Hash, &added, &fsize, &tsize, &t.Uploader, &seeders, &leechers, &downloads); err != nil {
+		return 0, t, err
+	}
+	if added.Valid {
+		t.Added = strconv.FormatInt(added.Int64, 10)
+	}
+	t.Fsize = strconv.FormatInt(fsize.Int64, 10)
+	if tsize.Int64 > 0 {
+		t.Tsize = strconv.FormatInt(tsize.Int64, 10)
+	}
+	for _, f := range []struct {
+		src sql.NullInt64
+		dst **int
+	}{{seeders, &t.Seeders}, {leechers, &t.Leechers}, {downloads, &t.Downloads}} {
+		if f.src.Valid {
+			v := int(f.src.Int64)
+			*f.dst = &v
+		}
+	}
+	return gid, t, nil
+}
+
+// exportJSONLines writes one JSON object per gallery to w.
+func (st *Store) exportJSONLines(w io.Writer, f ExportFilter) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	count := 0
+	err := st.forEachGallery(f, func(r *GalleryRecord) error {
+		count++
+		if count%100000 == 0 {
+			debugLog("Exported %d galleries (last gid %d)", count, r.Gid)
+		}
+		return enc.Encode(r)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// --- Export Command ---
+
+// parseDateFlag parses a YYYY-MM-DD date (UTC) into unix seconds; empty yields 0.
+func parseDateFlag(name, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --%s %q, expected YYYY-MM-DD", name, value)
+	}
+	return t.Unix(), nil
+}
+
+// openExportStore opens the store for reading. Tag translations are left out
+// when the database has none.
+func openExportStore(f ExportFilter) *Store {
+	st := openReadOnlyStore()
+	if f.TagLang != "" && !st.hasTranslations {
+		warnLog("The database has no tag_translation table; exporting without tag translations")
+	}
+	return st
+}
+
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "Output format: 'jsonl' or 'parquet'")
+	output := fs.String("output", "-", "Output file path ('-' for stdout); for parquet, the output directory")
+	gidFrom := fs.Int64("gid-from", 0, "Only export galleries with gid >= this value")
+	gidTo := fs.Int64("gid-to", 0, "Only export galleries with gid <= this value")
+	postedFrom := fs.String("posted-from", "", "Only export galleries posted on or after this date (YYYY-MM-DD, UTC)")
+	postedTo := fs.String("posted-to", "", "Only export galleries posted before this date (YYYY-MM-DD, UTC)")
+	category := fs.String("category", "", "Only export galleries in this category (e.g. 'Doujinshi')")
+	tag := fs.String("tag", "", "Only export galleries with this tag (e.g. 'female:glasses')")
+	lang := fs.String("lang", "", "Include tag translations in this language (see import-translations)")
+	since := fs.Int64("since", -1, "Export only galleries changed after this checkpoint (delta mode)")
+	checkpoint := fs.String("checkpoint", "", "Checkpoint file: read as --since when not given, updated after a delta export")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	if *output == "-" {
+		// Keep stdout clean for the JSON stream.
+		logToStderr()
+	}
+
+	if *since >= 0 || *checkpoint != "" {
+		if *format != "jsonl" {
+			errorLog("Delta exports only support the jsonl format")
+			os.Exit(1)
+		}
+		runDeltaExport(*output, *since, *checkpoint)
+		return
+	}
+
+	filter := ExportFilter{
+		GidFrom:  *gidFrom,
+		GidTo:    *gidTo,
+		Category: *category,
+		Tag:      *tag,
+		TagLang:  *lang,
+	}
+	var err error
+	if filter.PostedFrom, err = parseDateFlag("posted-from", *postedFrom); err != nil {
+		errorLog("%v", err)
+		os.Exit(1)
+	}
+	if filter.PostedTo, err = parseDateFlag("posted-to", *postedTo); err != nil {
+		errorLog("%v", err)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "jsonl":
+	case "parquet":
+		if *output == "-" {
+			errorLog("--output must name a directory for the parquet format")
+			os.Exit(1)
+		}
+		st := openExportStore(filter)
+		count, err := st.exportParquet(*output, filter)
+		if err != nil {
+			errorLog("Error exporting galleries: %v", err)
+			os.Exit(1)
+		}
+		infoLog("Exported %d galleries to %s", count, *output)
+		return
+	default:
+		errorLog("Unsupported export format: %s", *format)
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			errorLog("Error creating output file: %v", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	st := openExportStore(filter)
+	count, err := st.exportJSONLines(w, filter)
+	if err != nil {
+		errorLog("Error exporting galleries: %v", err)
+		os.Exit(1)
+	}
+	if *output != "-" {
+		infoLog("Exported %d galleries to %s", count, *output)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- Atom Feeds ---
+
+// Atom documents, see RFC 4287. Only the elements feed readers rely on are
+// written.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
+
+func atomTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+// galleryEntry turns a gallery into a feed entry that is updated at updated
+// (unix seconds).
+func galleryEntry(host string, g *GalleryRecord, updated int64) atomEntry {
+	link := galleryURL(host, g.GalleryMetadata)
+	e := atomEntry{
+		ID:        link,
+		Title:     g.Title,
+		Updated:   atomTime(updated),
+		Published: atomTime(g.Posted),
+		Links:     []atomLink{{Rel: "alternate", Href: link, Type: "text/html"}},
+		Content:   atomText{Type: "html", Body: galleryHTML(link, g)},
+	}
+	if g.Uploader != "" {
+		e.Author = &atomPerson{Name: g.Uploader}
+	}
+	for _, tag := range g.Tags {
+		e.Categories = append(e.Categories, atomCategory{Term: tag, Label: g.TagTranslations[tag]})
+	}
+	return e
+}
+
+// galleryHTML is the entry content: thumbnail, the details line and the tags
+// grouped by namespace.
+func galleryHTML(link string, g *GalleryRecord) string {
+	var b strings.Builder
+	if g.Thumb != "" {
+		fmt.Fprintf(&b, `<p><a href="%s"><img src="%s" alt=""></a></p>`, html.EscapeString(link), html.EscapeString(g.Thumb))
+	}
+	details := []string{g.Category, fmt.Sprintf("%d pages", g.Filecount), "rating " + g.Rating.String()}
+	if g.TitleJpn != "" {
+		details = append([]string{g.TitleJpn}, details...)
+	}
+	if g.Expunged {
+		details = append(details, "expunged")
+	}
+	fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(strings.Join(details, " · ")))
+
+	var namespaces []string
+	byNamespace := make(map[string][]string)
+	for _, tag := range g.Tags {
+		namespace, value := splitTag(tag)
+		if name := g.TagTranslations[tag]; name != "" {
+			value = name
+		}
+		if _, ok := byNamespace[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+		byNamespace[namespace] = append(byNamespace[namespace], value)
+	}
+	if len(namespaces) > 0 {
+		b.WriteString("<ul>")
+		for _, namespace := range namespaces {
+			fmt.Fprintf(&b, "<li>%s: %s</li>", html.EscapeString(namespace), html.EscapeString(strings.Join(byNamespace[namespace], ", ")))
+		}
+		b.WriteString("</ul>")
+	}
+	return b.String()
+}
+
+// feedTitle appends the search parameters of a feed request to base.
+func feedTitle(base string, params url.Values) string {
+	var parts []string
+	for _, name := range []string{"q", "tag", "title", "uploader", "category", "min_rating", "posted_from", "posted_to"} {
+		for _, v := range params[name] {
+			parts = append(parts, name+"="+v)
+		}
+	}
+	if len(parts) == 0 {
+		return base
+	}
+	return base + " (" + strings.Join(parts, ", ") + ")"
+}
+
+// expungedGalleries returns expunged galleries matching q, most recently changed
+// first, with the time of their last change. Galleries stored before the change
+// log existed, or all of them without one, fall back to their posted time.
+func (st *Store) expungedGalleries(q SearchQuery, limit int) ([]*GalleryRecord, map[int]int64, error) {
+	q.Expunged = true
+	where, args := st.searchWhere(q)
+	query := "SELECT g.gid, COALESCE(MAX(c.changed_at), g.posted) AS changed FROM gallery g " +
+		"LEFT JOIN gallery_change c ON c.gid = g.gid WHERE g.expunged = 1 AND " + where +
+		" GROUP BY g.gid, g.posted ORDER BY changed DESC, g.gid DESC LIMIT ?"
+	if !st.hasChangeLog {
+		query = "SELECT g.gid, g.posted AS changed FROM gallery g WHERE g.expunged = 1 AND " + where +
+			" ORDER BY changed DESC, g.gid DESC LIMIT ?"
+	}
+	rows, err := st.db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("querying expunged galleries: %w", err)
+	}
+	var gids []int
+	changed := make(map[int]int64)
+	for rows.Next() {
+		var gid int
+		var at int64
+		if err := rows.Scan(&gid, &at); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("scanning gallery: %w", err)
+		}
+		gids = append(gids, gid)
+		changed[gid] = at
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading expunged galleries: %w", err)
+	}
+
+	records, err := st.loadGalleryRecords(gids)
+	if err != nil {
+		return nil, nil, err
+	}
+	results := make([]*GalleryRecord, 0, len(gids))
+	for _, gid := range gids {
+		if r := records[gid]; r != nil {
+			results = append(results, r)
+		}
+	}
+	return results, changed, nil
+}
+
+// handleGalleryFeed serves the newest galleries matching the search parameters
+// of /api/search as an Atom feed.
+func (srv *Server) handleGalleryFeed(w http.ResponseWriter, r *http.Request) {
+	srv.serveFeed(w, r, "New galleries", func(q SearchQuery, limit int) ([]*GalleryRecord, map[int]int64, error) {
+		results, err := srv.st.searchGalleries(q, false, limit, 0)
+		return results, nil, err
+	})
+}
+
+// handleExpungedFeed serves the galleries that were most recently marked
+// expunged (or stored already expunged), optionally narrowed by a search.
+func (srv *Server) handleExpungedFeed(w http.ResponseWriter, r *http.Request) {
+	srv.serveFeed(w, r, "Newly expunged galleries", srv.st.expungedGalleries)
+}
+
+// serveFeed runs a feed query and writes the result. Entries are updated at the
+// time in the returned map, or at their posted time when it has none.
+func (srv *Server) serveFeed(w http.ResponseWriter, r *http.Request, title string, load func(SearchQuery, int) ([]*GalleryRecord, map[int]int64, error)) {
+	params := r.URL.Query()
+	q, err := searchParams(params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	limit, err := pageSize(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	results, updated, err := load(q, limit)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if lang := params.Get("lang"); lang != "" && len(results) > 0 {
+		if err := srv.st.attachTagTranslations(results, lang); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	self := scheme + "://" + r.Host + r.URL.RequestURI()
+	feed := atomFeed{
+		ID:     self,
+		Title:  feedTitle(title, params),
+		Author: atomPerson{Name: "e-hentai-sync"},
+		Links:  []atomLink{{Rel: "self", Href: self, Type: "application/atom+xml"}},
+	}
+	var newest int64
+	for _, g := range results {
+		at, ok := updated[g.Gid]
+		if !ok {
+			at = g.Posted
+		}
+		if at > newest {
+			newest = at
+		}
+		feed.Entries = append(feed.Entries, galleryEntry(srv.host, g, at))
+	}
+	if newest == 0 {
+		newest = time.Now().Unix()
+	}
+	feed.Updated = atomTime(newest)
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		errorLog("Error writing feed: %v", err)
+	}
+}
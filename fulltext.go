@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- Full-Text Title Index ---
+
+// sqliteFullTextDDL creates gallery_fts, an FTS5 index over the titles stored in
+// gallery (external content, rowid = gid). The trigram tokenizer matches any
+// substring of three or more characters, which also covers Japanese titles
+// without word boundaries. The triggers keep the index in sync with every write
+// to gallery, whether by the importer or apply-delta.
+var sqliteFullTextDDL = []string{
+	"CREATE VIRTUAL TABLE gallery_fts USING fts5(title, title_jpn, content='gallery', content_rowid='gid', tokenize='trigram')",
+	"CREATE TRIGGER gallery_fts_insert AFTER INSERT ON gallery BEGIN " +
+		"INSERT INTO gallery_fts (rowid, title, title_jpn) VALUES (new.gid, new.title, new.title_jpn); END",
+	"CREATE TRIGGER gallery_fts_delete AFTER DELETE ON gallery BEGIN " +
+		"INSERT INTO gallery_fts (gallery_fts, rowid, title, title_jpn) VALUES ('delete', old.gid, old.title, old.title_jpn); END",
+	"CREATE TRIGGER gallery_fts_update AFTER UPDATE OF title, title_jpn ON gallery BEGIN " +
+		"INSERT INTO gallery_fts (gallery_fts, rowid, title, title_jpn) VALUES ('delete', old.gid, old.title, old.title_jpn); " +
+		"INSERT INTO gallery_fts (rowid, title, title_jpn) VALUES (new.gid, new.title, new.title_jpn); END",
+	"INSERT INTO gallery_fts (gallery_fts) VALUES ('rebuild')",
+}
+
+var sqliteDropFullTextDDL = []string{
+	"DROP TRIGGER IF EXISTS gallery_fts_insert",
+	"DROP TRIGGER IF EXISTS gallery_fts_delete",
+	"DROP TRIGGER IF EXISTS gallery_fts_update",
+	"DROP TABLE IF EXISTS gallery_fts",
+}
+
+// sqliteTrigramLength is the shortest text the trigram tokenizer can match.
+const sqliteTrigramLength = 3
+
+func (st *Store) hasFullTextIndex() (bool, error) {
+	var n int
+	var err error
+	if st.isSQLite() {
+		err = st.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'gallery_fts'").Scan(&n)
+	} else {
+		err = st.db.QueryRow("SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'gallery' AND INDEX_NAME = 'ft_title'").Scan(&n)
+	}
+	if err != nil {
+		return false, fmt.Errorf("checking full-text index: %w", err)
+	}
+	return n > 0, nil
+}
+
+// detectFullText sets fullTextMin when the title full-text index exists. On
+// SQLite the index is only usable (and gallery only writable, because of the
+// triggers) when the binary was built with FTS5 support.
+func (st *Store) detectFullText() error {
+	st.fullTextMin = 0
+	ok, err := st.hasFullTextIndex()
+	if err != nil || !ok {
+		return err
+	}
+	if st.isSQLite() {
+		if _, err := st.db.Exec("SELECT rowid FROM gallery_fts LIMIT 0"); err != nil {
+			return fmt.Errorf("the database has a full-text index but it can't be used (%v); build with -tags \"sqlite sqlite_fts5\" or drop it with create-fulltext --drop", err)
+		}
+		st.fullTextMin = sqliteTrigramLength
+		return nil
+	}
+	// Terms shorter than the ngram size can't be found through the index.
+	st.fullTextMin = 2
+	var size int
+	if err := st.db.QueryRow("SELECT @@ngram_token_size").Scan(&size); err == nil && size > 0 {
+		st.fullTextMin = size
+	}
+	return nil
+}
+
+// fullTextCondition matches galleries whose titles contain text through the
+// full-text index. ok is false when the index can't match text, in which case
+// the caller falls back to LIKE.
+func (st *Store) fullTextCondition(text string) (string, []interface{}, bool) {
+	if st.fullTextMin == 0 || len([]rune(text)) < st.fullTextMin {
+		return "", nil, false
+	}
+	if st.isSQLite() {
+		phrase := `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+		return "g.gid IN (SELECT rowid FROM gallery_fts WHERE gallery_fts MATCH ?)", []interface{}{phrase}, true
+	}
+	phrase := `"` + strings.ReplaceAll(text, `"`, " ") + `"`
+	return "MATCH (g.title, g.title_jpn) AGAINST (? IN BOOLEAN MODE)", []interface{}{phrase}, true
+}
+
+// createFullText adds the title full-text index; see migration_fulltext.sql for
+// the MySQL equivalent.
+func (st *Store) createFullText() error {
+	stmts := sqliteFullTextDDL
+	if !st.isSQLite() {
+		stmts = []string{"ALTER TABLE gallery ADD FULLTEXT INDEX ft_title (title, title_jpn) WITH PARSER ngram"}
+	}
+	for _, stmt := range stmts {
+		if _, err := st.db.Exec(stmt); err != nil {
+			return fmt.Errorf("creating full-text index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (st *Store) dropFullText() error {
+	stmts := sqliteDropFullTextDDL
+	if !st.isSQLite() {
+		stmts = []string{"ALTER TABLE gallery DROP INDEX ft_title"}
+	}
+	for _, stmt := range stmts {
+		if _, err := st.db.Exec(stmt); err != nil {
+			return fmt.Errorf("dropping full-text index: %w", err)
+		}
+	}
+	return nil
+}
+
+func runCreateFullText(args []string) {
+	fs := flag.NewFlagSet("create-fulltext", flag.ExitOnError)
+	drop := fs.Bool("drop", false, "Remove the full-text index instead")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	st := openStore(loadConfig())
+	exists, err := st.hasFullTextIndex()
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	if *drop {
+		if !exists {
+			infoLog("There is no full-text index")
+			return
+		}
+		if err := st.dropFullText(); err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+		infoLog("Dropped the full-text index")
+		return
+	}
+	if exists {
+		infoLog("The full-text index already exists")
+		return
+	}
+	infoLog("Indexing gallery titles, this may take a while on large databases")
+	if err := st.createFullText(); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	infoLog("Created the full-text index")
+}
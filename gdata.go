@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// --- gdata-Compatible API ---
+
+// maxGdataEntries is the number of galleries the site accepts per gdata request.
+const maxGdataEntries = 25
+
+// apiRequest is a request to api.php. Only the fields of the supported methods
+// are decoded.
+type apiRequest struct {
+	Method    string              `json:"method"`
+	Gidlist   [][]json.RawMessage `json:"gidlist"`
+	Namespace int                 `json:"namespace"`
+}
+
+// gdataError is the entry the site returns for an unknown gid or a wrong token.
+type gdataError struct {
+	Gid   int    `json:"gid"`
+	Error string `json:"error"`
+}
+
+const gdataKeyError = "Key missing, or incorrect key provided."
+
+// parseGidlistEntry reads a [gid, "token"] pair; the gid may be a number or a string.
+func parseGidlistEntry(entry []json.RawMessage) (int, string, error) {
+	if len(entry) != 2 {
+		return 0, "", fmt.Errorf("gidlist entries must be [gid, token]")
+	}
+	var gid int
+	if err := json.Unmarshal(entry[0], &gid); err != nil {
+		var s string
+		if err := json.Unmarshal(entry[0], &s); err != nil {
+			return 0, "", fmt.Errorf("invalid gid %s", entry[0])
+		}
+		if gid, err = strconv.Atoi(s); err != nil {
+			return 0, "", fmt.Errorf("invalid gid %q", s)
+		}
+	}
+	var token string
+	if err := json.Unmarshal(entry[1], &token); err != nil {
+		return 0, "", fmt.Errorf("invalid token %s", entry[1])
+	}
+	return gid, token, nil
+}
+
+// parseGidlist validates the gidlist of a gdata request.
+func parseGidlist(list [][]json.RawMessage) ([]int, []string, error) {
+	if len(list) > maxGdataEntries {
+		return nil, nil, fmt.Errorf("too many galleries requested (at most %d)", maxGdataEntries)
+	}
+	gids := make([]int, len(list))
+	tokens := make([]string, len(list))
+	for i, entry := range list {
+		var err error
+		if gids[i], tokens[i], err = parseGidlistEntry(entry); err != nil {
+			return nil, nil, err
+		}
+	}
+	return gids, tokens, nil
+}
+
+// gdata answers a gdata request from the store, in the order requested and in
+// the shape of the site's APIResponse. Without namespace set, tags are returned
+// without their namespace like the site does.
+func (st *Store) gdata(gids []int, tokens []string, namespace bool) ([]interface{}, error) {
+	records, err := st.loadGalleryRecords(gids)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]interface{}, 0, len(gids))
+	for i, gid := range gids {
+		r := records[gid]
+		if r == nil || r.Token != tokens[i] {
+			entries = append(entries, gdataError{Gid: gid, Error: gdataKeyError})
+			continue
+		}
+		g := r.GalleryMetadata
+		// The site's torrents have no scraped fields.
+		g.Torrents = make([]TorrentInfo, len(r.Torrents))
+		for j, t := range r.Torrents {
+			g.Torrents[j] = TorrentInfo{Hash: t.Hash, Added: t.Added, Name: t.Name, Tsize: t.Tsize, Fsize: t.Fsize}
+		}
+		if !namespace {
+			tags := make([]string, len(g.Tags))
+			for j, name := range g.Tags {
+				_, tags[j] = splitTag(name)
+			}
+			g.Tags = tags
+		}
+		entries = append(entries, g)
+	}
+	return entries, nil
+}
+
+// handleAPI serves POST /api.php like the site's API, so tools written against
+// it can be pointed at the mirror.
+func (srv *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	var req apiRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	switch strings.ToLower(req.Method) {
+	case "gdata":
+		gids, tokens, err := parseGidlist(req.Gidlist)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		entries, err := srv.st.gdata(gids, tokens, req.Namespace == 1)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"gmetadata": entries})
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported method %q", req.Method)
+	}
+}
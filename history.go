@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// --- Gallery History ---
+
+// historyDDL creates gallery_history, which keeps one row per changed field
+// every time a sync sees a gallery with different values than stored.
+var historyDDL = map[string][]string{
+	"mysql": {
+		"CREATE TABLE IF NOT EXISTS `gallery_history` (" +
+			"`id` bigint(20) NOT NULL AUTO_INCREMENT, " +
+			"`gid` int(11) NOT NULL, " +
+			"`field` varchar(20) NOT NULL, " +
+			"`old_value` varchar(512) DEFAULT NULL, " +
+			"`new_value` varchar(512) DEFAULT NULL, " +
+			"`changed_at` int(11) NOT NULL, " +
+			"PRIMARY KEY (`id`), KEY `gid` (`gid`)" +
+			") ENGINE=MyISAM DEFAULT CHARSET=utf8mb4",
+	},
+	"sqlite3": {
+		"CREATE TABLE IF NOT EXISTS gallery_history (id INTEGER PRIMARY KEY AUTOINCREMENT, gid INTEGER NOT NULL, field TEXT NOT NULL, old_value TEXT, new_value TEXT, changed_at INTEGER NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS gallery_history_gid ON gallery_history (gid)",
+	},
+}
+
+// HistoryEntry is a single field-level change of a gallery.
+type HistoryEntry struct {
+	Gid       int    `json:"gid"`
+	Field     string `json:"field"`
+	OldValue  string `json:"old_value"`
+	NewValue  string `json:"new_value"`
+	ChangedAt int64  `json:"changed_at"`
+}
+
+func (st *Store) recordHistory(gid int, changes []fieldChange) error {
+	now := time.Now().Unix()
+	for _, c := range changes {
+		_, err := st.db.Exec("INSERT INTO gallery_history (gid, field, old_value, new_value, changed_at) VALUES (?, ?, ?, ?, ?)",
+			gid, c.Field, c.Old, c.New, now)
+		if err != nil {
+			return fmt.Errorf("inserting history for gid %d field %s: %w", gid, c.Field, err)
+		}
+	}
+	debugLog("Recorded %d changed fields for gid %d", len(changes), gid)
+	return nil
+}
+
+func (st *Store) galleryHistory(gid int) ([]HistoryEntry, error) {
+	rows, err := st.db.Query("SELECT gid, field, COALESCE(old_value, ''), COALESCE(new_value, ''), changed_at FROM gallery_history WHERE gid = ? ORDER BY changed_at, id", gid)
+	if err != nil {
+		return nil, fmt.Errorf("querying history: %w", err)
+	}
+	defer rows.Close()
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.Gid, &e.Field, &e.OldValue, &e.NewValue, &e.ChangedAt); err != nil {
+			return nil, fmt.Errorf("scanning history: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	gid := fs.Int("gid", 0, "Gallery id to show the history of")
+	asJSON := fs.Bool("json", false, "Print the history as JSON")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	if *gid <= 0 {
+		errorLog("--gid is required")
+		os.Exit(1)
+	}
+	if *asJSON {
+		logToStderr()
+	}
+
+	st := openReadOnlyStore()
+	if !st.hasHistory {
+		errorLog("The database has no gallery_history table; run sync with --history first")
+		os.Exit(1)
+	}
+	entries, err := st.galleryHistory(*gid)
+	if err != nil {
+		errorLog("Error reading history: %v", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		if entries == nil {
+			entries = []HistoryEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return
+	}
+	if len(entries) == 0 {
+		infoLog("No recorded changes for gid %d", *gid)
+		return
+	}
+	data := pterm.TableData{{"Changed At (UTC)", "Field", "Old", "New"}}
+	for _, e := range entries {
+		data = append(data, []string{time.Unix(e.ChangedAt, 0).UTC().Format("2006-01-02 15:04"), e.Field, e.OldValue, e.NewValue})
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Gallery ID Import ---
+
+// galleryRef is a gallery named in an import list. Token may be unknown;
+// an image page URL gives a page token and page number instead.
+type galleryRef struct {
+	Gid       int
+	Token     string
+	PageToken string
+	Page      int
+}
+
+var (
+	galleryURLPattern = regexp.MustCompile(`/g/(\d+)/([0-9a-f]{10})`)
+	listingURLPattern = regexp.MustCompile(`[?&]gid=(\d+)&t=([0-9a-f]{10})`)
+	imageURLPattern   = regexp.MustCompile(`/s/([0-9a-f]{10})/(\d+)-(\d+)`)
+	tokenPattern      = regexp.MustCompile(`^[0-9a-f]{10}$`)
+)
+
+// parseGalleryRef reads one line of an import list: a gallery or image page
+// URL, "gid token", "gid page_token page" (separated by spaces, commas, slashes
+// or tabs) or a bare gid.
+func parseGalleryRef(line string) (galleryRef, error) {
+	for _, re := range []*regexp.Regexp{galleryURLPattern, listingURLPattern} {
+		if m := re.FindStringSubmatch(line); m != nil {
+			gid, _ := strconv.Atoi(m[1])
+			return galleryRef{Gid: gid, Token: m[2]}, nil
+		}
+	}
+	if m := imageURLPattern.FindStringSubmatch(line); m != nil {
+		gid, _ := strconv.Atoi(m[2])
+		page, _ := strconv.Atoi(m[3])
+		return galleryRef{Gid: gid, PageToken: m[1], Page: page}, nil
+	}
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ',' || r == '/'
+	})
+	if len(fields) == 0 || len(fields) > 3 {
+		return galleryRef{}, fmt.Errorf("expected a gallery or image page URL, a gid with token or page token, or a gid")
+	}
+	gid, err := strconv.Atoi(fields[0])
+	if err != nil || gid <= 0 {
+		return galleryRef{}, fmt.Errorf("invalid gid %q", fields[0])
+	}
+	ref := galleryRef{Gid: gid}
+	if len(fields) > 1 && !tokenPattern.MatchString(fields[1]) {
+		return galleryRef{}, fmt.Errorf("invalid token %q", fields[1])
+	}
+	switch len(fields) {
+	case 2:
+		ref.Token = fields[1]
+	case 3:
+		ref.PageToken = fields[1]
+		if ref.Page, err = strconv.Atoi(fields[2]); err != nil || ref.Page <= 0 {
+			return galleryRef{}, fmt.Errorf("invalid page number %q", fields[2])
+		}
+	}
+	return ref, nil
+}
+
+// readGalleryRefs parses an import list. Empty lines and lines starting with #
+// are skipped; a gid listed more than once keeps the first known token.
+func readGalleryRefs(r io.Reader) ([]galleryRef, error) {
+	var refs []galleryRef
+	index := make(map[int]int)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ref, err := parseGalleryRef(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if i, ok := index[ref.Gid]; ok {
+			if refs[i].Token == "" {
+				refs[i].Token = ref.Token
+			}
+			if refs[i].PageToken == "" {
+				refs[i].PageToken, refs[i].Page = ref.PageToken, ref.Page
+			}
+			continue
+		}
+		index[ref.Gid] = len(refs)
+		refs = append(refs, ref)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading input: %w", err)
+	}
+	return refs, nil
+}
+
+// resolveStoredTokens fills in missing tokens from the database.
+func (st *Store) resolveStoredTokens(refs []galleryRef) (int, error) {
+	var gids []int
+	for _, ref := range refs {
+		if ref.Token == "" {
+			gids = append(gids, ref.Gid)
+		}
+	}
+	if len(gids) == 0 {
+		return 0, nil
+	}
+	records, err := st.loadGalleryRecords(gids)
+	if err != nil {
+		return 0, err
+	}
+	resolved := 0
+	for i := range refs {
+		if r := records[refs[i].Gid]; refs[i].Token == "" && r != nil && r.Token != "" {
+			refs[i].Token = r.Token
+			resolved++
+		}
+	}
+	return resolved, nil
+}
+
+// gtokenEntry is one result of the gtoken API method.
+type gtokenEntry struct {
+	Gid   int    `json:"gid"`
+	Token string `json:"token"`
+	Error string `json:"error"`
+}
+
+// getGalleryTokens resolves gallery tokens from image pages with the gtoken API
+// method. Entries the API has no token for come back with Error set.
+func (s *Sync) getGalleryTokens(pages []galleryRef) ([]gtokenEntry, error) {
+	pagelist := make([][]interface{}, len(pages))
+	for i, p := range pages {
+		pagelist[i] = []interface{}{p.Gid, p.PageToken, p.Page}
+	}
+	payload := map[string]interface{}{
+		"method":   "gtoken",
+		"pagelist": pagelist,
+	}
+	var resp struct {
+		Tokenlist []gtokenEntry `json:"tokenlist"`
+		Error     string        `json:"error"`
+	}
+	err := s.callAPI(payload, func(body []byte) error {
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return err
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("API error: %s", resp.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Tokenlist, nil
+}
+
+// resolvePageTokens fills in missing tokens of galleries named by an image page.
+func (s *Sync) resolvePageTokens(refs []galleryRef) int {
+	index := make(map[int]int)
+	var pages []galleryRef
+	for i, ref := range refs {
+		if ref.Token == "" && ref.PageToken != "" {
+			index[ref.Gid] = i
+			pages = append(pages, ref)
+		}
+	}
+	resolved := 0
+	for start := 0; start < len(pages); start += maxGdataEntries {
+		end := start + maxGdataEntries
+		if end > len(pages) {
+			end = len(pages)
+		}
+		if start > 0 {
+			time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
+		}
+		tokens, err := s.getGalleryTokens(pages[start:end])
+		if err != nil {
+			errorLog("Error resolving page tokens: %v", err)
+			continue
+		}
+		for _, t := range tokens {
+			i, ok := index[t.Gid]
+			switch {
+			case !ok:
+				continue
+			case t.Error != "" || !tokenPattern.MatchString(t.Token):
+				logFields{"gid", t.Gid}.warnLog("API returned no token for page %d of gid %d: %s", refs[i].Page, t.Gid, t.Error)
+			default:
+				refs[i].Token = t.Token
+				resolved++
+			}
+		}
+	}
+	return resolved
+}
+
+// resolveListingTokens looks up missing tokens on the listing: the page of
+// galleries older than gid+1 (expunged ones included) starts with the gallery
+// itself unless it was removed. One page usually resolves neighbouring gids too.
+func (s *Sync) resolveListingTokens(refs []galleryRef) int {
+	missing := make(map[int]int)
+	var gids []int
+	for i, ref := range refs {
+		if ref.Token == "" {
+			missing[ref.Gid] = i
+			gids = append(gids, ref.Gid)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(gids)))
+
+	profile := &CrawlProfile{Expunged: true}
+	resolved := 0
+	for _, gid := range gids {
+		if _, ok := missing[gid]; !ok {
+			continue // found on an earlier page
+		}
+		time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
+		var fetchURL string
+		var entries []PageEntry
+		var err error
+		for attempt := 0; attempt < s.config.RetryCount; attempt++ {
+			fetchURL, entries, err = s.getListingPage("next="+strconv.Itoa(gid+1), profile)
+			if err == nil {
+				break
+			}
+			logFields{"url", fetchURL, "attempt", attempt + 1}.errorLog("Error fetching page on attempt %d: %v", attempt+1, err)
+			time.Sleep(1 * time.Second)
+		}
+		if err != nil {
+			logFields{"gid", gid}.errorLog("Error looking up the token of gid %d: %v", gid, err)
+			delete(missing, gid)
+			continue
+		}
+		for _, e := range entries {
+			entryGid, err := strconv.Atoi(e.GID)
+			if err != nil {
+				continue
+			}
+			if j, ok := missing[entryGid]; ok {
+				refs[j].Token = e.Token
+				delete(missing, entryGid)
+				resolved++
+			}
+		}
+		if _, ok := missing[gid]; ok {
+			debugLog("Gid %d is not on the listing", gid)
+			delete(missing, gid)
+		}
+	}
+	return resolved
+}
+
+// --- Import IDs Command ---
+
+func runImportIDs(args []string) {
+	fs := flag.NewFlagSet("import-ids", flag.ExitOnError)
+	input := fs.String("input", "-", "File with one gallery or image page URL, 'gid token', 'gid page_token page' or gid per line ('-' for stdin)")
+	site := fs.String("site", "e-hentai", "Target site: 'e-hentai' or 'exhentai'")
+	cookieFile := fs.String("cookie-file", "", "Path to cookie JSON file (required for exhentai)")
+	noLookup := fs.Bool("no-lookup", false, "Don't look up missing tokens on the site listing, only in the database")
+	unresolvedFile := fs.String("unresolved", "", "Write the galleries whose token could not be found or that could not be imported to this file")
+	history := fs.Bool("history", false, "Record field-level gallery changes in the gallery_history table")
+	scrapeTorrents := fs.Bool("scrape-torrents", false, "Also scrape torrent pages of imported galleries")
+	enrich := fs.Bool("enrich", false, "Also fetch gallery pages of imported galleries")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+	if *history {
+		viper.Set("history", true)
+	}
+
+	in := io.Reader(os.Stdin)
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+	refs, err := readGalleryRefs(in)
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+
+	s := NewSync(Options{Site: *site, CookieFile: *cookieFile, ScrapeTorrents: *scrapeTorrents, Enrich: *enrich})
+	if err := s.setNames(); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	fromDB, err := s.resolveStoredTokens(refs)
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	fromPages := s.resolvePageTokens(refs)
+	fromListing := 0
+	if !*noLookup {
+		fromListing = s.resolveListingTokens(refs)
+	}
+
+	var entries []PageEntry
+	var unresolved []int
+	for _, ref := range refs {
+		if ref.Token == "" {
+			unresolved = append(unresolved, ref.Gid)
+			continue
+		}
+		entries = append(entries, PageEntry{GID: strconv.Itoa(ref.Gid), Token: ref.Token})
+	}
+	infoLog("Read %d galleries (%d tokens from the database, %d from image pages, %d from the listing, %d unresolved)", len(refs), fromDB, fromPages, fromListing, len(unresolved))
+
+	imported, unavailable, err := s.importPage(entries)
+	s.closeWatches()
+	var failed []int
+	if err != nil {
+		var ie *importError
+		if !errors.As(err, &ie) {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+		failed = ie.Gids
+	}
+	for _, gid := range unresolved {
+		logFields{"gid", gid}.warnLog("No token found for gid %d", gid)
+	}
+	for _, gid := range unavailable {
+		logFields{"gid", gid}.warnLog("The API returned no gallery for gid %d; the token is wrong or the gallery was removed", gid)
+	}
+	// Unavailable galleries count as failed: their token came from the input or
+	// the database and needs checking.
+	failed = append(failed, unavailable...)
+	if *unresolvedFile != "" {
+		// Failed galleries keep their token, so the file can be imported again.
+		tokens := make(map[int]string, len(refs))
+		for _, ref := range refs {
+			tokens[ref.Gid] = ref.Token
+		}
+		var b strings.Builder
+		for _, gid := range unresolved {
+			fmt.Fprintln(&b, gid)
+		}
+		for _, gid := range failed {
+			fmt.Fprintln(&b, gid, tokens[gid])
+		}
+		if err := os.WriteFile(*unresolvedFile, []byte(b.String()), 0644); err != nil {
+			errorLog("Error writing unresolved gids: %v", err)
+		}
+	}
+	infoLog("Imported %d of %d galleries", imported, len(refs))
+	if len(failed) > 0 {
+		errorLog("%d galleries could not be imported", len(failed))
+		os.Exit(1)
+	}
+}
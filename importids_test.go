@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGalleryRef(t *testing.T) {
+	tests := []struct {
+		line string
+		want galleryRef
+	}{
+		{"https://e-hentai.org/g/618395/0439fa3666/", galleryRef{Gid: 618395, Token: "0439fa3666"}},
+		{"https://exhentai.org/g/618395/0439fa3666/?p=2", galleryRef{Gid: 618395, Token: "0439fa3666"}},
+		{"https://e-hentai.org/index.php?gid=618395&t=0439fa3666", galleryRef{Gid: 618395, Token: "0439fa3666"}},
+		{"https://e-hentai.org/s/5c1b2f0e3d/618395-3", galleryRef{Gid: 618395, PageToken: "5c1b2f0e3d", Page: 3}},
+		{"618395 0439fa3666", galleryRef{Gid: 618395, Token: "0439fa3666"}},
+		{"618395,0439fa3666", galleryRef{Gid: 618395, Token: "0439fa3666"}},
+		{"618395/0439fa3666", galleryRef{Gid: 618395, Token: "0439fa3666"}},
+		{"618395\t5c1b2f0e3d\t3", galleryRef{Gid: 618395, PageToken: "5c1b2f0e3d", Page: 3}},
+		{"618395", galleryRef{Gid: 618395}},
+	}
+	for _, tt := range tests {
+		got, err := parseGalleryRef(tt.line)
+		if err != nil {
+			t.Errorf("parseGalleryRef(%q): %v", tt.line, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseGalleryRef(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseGalleryRefErrors(t *testing.T) {
+	for _, line := range []string{
+		"abc",
+		"0",
+		"-5",
+		"618395 xyz",
+		"618395 0439FA3666",
+		"618395 5c1b2f0e3d 0",
+		"618395 5c1b2f0e3d x",
+		"1 2 3 4",
+	} {
+		if _, err := parseGalleryRef(line); err == nil {
+			t.Errorf("parseGalleryRef(%q): expected an error", line)
+		}
+	}
+}
+
+func TestReadGalleryRefs(t *testing.T) {
+	input := `# comment
+
+618395
+https://e-hentai.org/s/5c1b2f0e3d/618395-3
+618395 0439fa3666
+700000 1111111111
+700000 2222222222
+`
+	got, err := readGalleryRefs(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []galleryRef{
+		{Gid: 618395, Token: "0439fa3666", PageToken: "5c1b2f0e3d", Page: 3},
+		{Gid: 700000, Token: "1111111111"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readGalleryRefs = %+v, want %+v", got, want)
+	}
+
+	if _, err := readGalleryRefs(strings.NewReader("1\nbad line\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("readGalleryRefs error = %v, want a line 2 error", err)
+	}
+}
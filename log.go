@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+)
+
+// --- Logging ---
+
+// debugMode is set by --debug and forces the debug level.
+var debugMode bool
+
+var (
+	// logger receives everything logged through the helpers below. Until
+	// setupLogging runs it prints pretty terminal output at the info level.
+	logger = slog.New(&prettyHandler{level: slog.LevelInfo})
+	// logOutput is where text and JSON logs go; see logToStderr.
+	logOutput io.Writer = os.Stdout
+	// progressEnabled shows live progress areas and bars; otherwise progress
+	// is logged line by line.
+	progressEnabled = isTerminal(os.Stdout)
+	// logFile is the open --log-file, if any.
+	logFile     *os.File
+	logSettings string // the settings logging was last set up with
+)
+
+// Log formats: pretty is pterm output for terminals, text and json are slog's
+// handlers. auto picks pretty on a terminal and text otherwise.
+const (
+	logFormatAuto   = "auto"
+	logFormatPretty = "pretty"
+	logFormatText   = "text"
+	logFormatJSON   = "json"
+)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// setupLogging configures the logger from the log.* settings (config file,
+// LOG_* environment variables or --log-* flags). It is called by loadConfig
+// and does nothing when the settings haven't changed.
+func setupLogging() error {
+	format := strings.ToLower(viper.GetString("log.format"))
+	levelName := viper.GetString("log.level")
+	path := viper.GetString("log.file")
+	key := fmt.Sprint(format, "|", levelName, "|", path, "|", debugMode, "|", logOutput == os.Stderr)
+	if key == logSettings {
+		return nil
+	}
+	logSettings = key
+
+	level, err := parseLogLevel(levelName)
+	if err != nil {
+		return err
+	}
+	if debugMode {
+		level = slog.LevelDebug
+	}
+	if format == "" || format == logFormatAuto {
+		format = logFormatText
+		if isTerminal(os.Stdout) {
+			format = logFormatPretty
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handlers []slog.Handler
+	switch format {
+	case logFormatPretty:
+		handlers = append(handlers, &prettyHandler{level: level})
+		if level <= slog.LevelDebug {
+			pterm.EnableDebugMessages()
+		}
+	case logFormatText:
+		handlers = append(handlers, slog.NewTextHandler(logOutput, opts))
+	case logFormatJSON:
+		handlers = append(handlers, slog.NewJSONHandler(logOutput, opts))
+	default:
+		return fmt.Errorf("invalid log format %q (expected auto, pretty, text or json)", format)
+	}
+	if format != logFormatPretty {
+		// Keep escape codes out of tables and other output.
+		pterm.DisableStyling()
+		progressEnabled = false
+	} else {
+		progressEnabled = isTerminal(os.Stdout)
+	}
+
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("opening log file: %w", err)
+		}
+		logFile = f
+		if format == logFormatJSON {
+			handlers = append(handlers, slog.NewJSONHandler(f, opts))
+		} else {
+			handlers = append(handlers, slog.NewTextHandler(f, opts))
+		}
+	}
+	if len(handlers) == 1 {
+		logger = slog.New(handlers[0])
+	} else {
+		logger = slog.New(multiHandler(handlers))
+	}
+	return nil
+}
+
+// logToStderr routes logs to stderr so stdout can carry data such as JSON output.
+func logToStderr() {
+	logOutput = os.Stderr
+	pterm.SetDefaultOutput(os.Stderr)
+	pterm.Debug.Writer = os.Stderr
+	pterm.Info.Writer = os.Stderr
+	pterm.Warning.Writer = os.Stderr
+	pterm.Error.Writer = os.Stderr
+	if logSettings != "" {
+		if err := setupLogging(); err != nil {
+			errorLog("%v", err)
+		}
+	}
+}
+
+// logFields attaches key/value pairs such as gid, batch or url to log lines:
+//
+//	logFields("gid", gid).errorLog("Error saving gallery: %v", err)
+type logFields []any
+
+func (f logFields) log(level slog.Level, format string, a ...interface{}) {
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	logger.Log(context.Background(), level, fmt.Sprintf(format, a...), f...)
+}
+
+func (f logFields) debugLog(format string, a ...interface{}) { f.log(slog.LevelDebug, format, a...) }
+func (f logFields) infoLog(format string, a ...interface{})  { f.log(slog.LevelInfo, format, a...) }
+func (f logFields) warnLog(format string, a ...interface{})  { f.log(slog.LevelWarn, format, a...) }
+func (f logFields) errorLog(format string, a ...interface{}) { f.log(slog.LevelError, format, a...) }
+
+func debugLog(format string, a ...interface{}) { logFields(nil).debugLog(format, a...) }
+func infoLog(format string, a ...interface{})  { logFields(nil).infoLog(format, a...) }
+func warnLog(format string, a ...interface{})  { logFields(nil).warnLog(format, a...) }
+func errorLog(format string, a ...interface{}) { logFields(nil).errorLog(format, a...) }
+
+// prettyHandler prints log records with pterm's prefix printers, with any
+// fields appended as key=value.
+type prettyHandler struct {
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func (h *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *prettyHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	appendAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(appendAttr)
+	switch {
+	case r.Level >= slog.LevelError:
+		pterm.Error.Println(b.String())
+	case r.Level >= slog.LevelWarn:
+		pterm.Warning.Println(b.String())
+	case r.Level >= slog.LevelInfo:
+		pterm.Info.Println(b.String())
+	default:
+		pterm.Debug.Println(b.String())
+	}
+	return nil
+}
+
+func (h *prettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &prettyHandler{level: h.level, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+// WithGroup is not needed by the helpers; groups are flattened.
+func (h *prettyHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// multiHandler sends records to several handlers, e.g. the console and a log file.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(multiHandler, len(m))
+	for i, h := range m {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return hs
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	hs := make(multiHandler, len(m))
+	for i, h := range m {
+		hs[i] = h.WithGroup(name)
+	}
+	return hs
+}
+
+// --- Progress ---
+
+// pageProgress shows the progress of a listing crawl: a live area on a
+// terminal, one log line per page otherwise.
+type pageProgress struct {
+	area  *pterm.AreaPrinter
+	label string // "" or "Expunged "
+}
+
+func newPageProgress(expunged bool) *pageProgress {
+	p := &pageProgress{}
+	if expunged {
+		p.label = "Expunged "
+	}
+	if progressEnabled {
+		p.area, _ = pterm.DefaultArea.Start()
+	}
+	return p
+}
+
+func (p *pageProgress) update(fetchURL, newestEntryDate string, pageCount, apiCount int) {
+	if p.area == nil {
+		logFields{"url", fetchURL, "newest", newestEntryDate, "page_entries", pageCount, "api_entries", apiCount}.infoLog("Fetched %spage", strings.ToLower(p.label))
+		return
+	}
+	bulletItems := []pterm.BulletListItem{
+		{Level: 1, Text: fmt.Sprintf("Newest %sEntry Date: %s", p.label, newestEntryDate)},
+		{Level: 1, Text: fmt.Sprintf("Fetched %sPage Entries: %d", p.label, pageCount)},
+		{Level: 1, Text: fmt.Sprintf("Fetched %sAPI Entries: %d", p.label, apiCount)},
+	}
+	bulletStr, _ := pterm.DefaultBulletList.WithItems(bulletItems).Srender()
+	p.area.Update("Fetched page from " + fetchURL + "\n" + bulletStr)
+}
+
+func (p *pageProgress) stop() {
+	if p.area != nil {
+		p.area.Stop()
+	}
+}
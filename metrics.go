@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- Metrics ---
+
+// Metrics counts what the sync does, for the Prometheus text format. A nil
+// *Metrics is valid and records nothing, so commands without metrics don't
+// need to check.
+type Metrics struct {
+	pagesFetched     atomic.Int64
+	pageRetries      atomic.Int64
+	apiBatches       atomic.Int64
+	apiBatchFailures atomic.Int64
+	apiRetries       atomic.Int64
+	galleriesNew     atomic.Int64
+	galleriesUpdated atomic.Int64
+	tagsCreated      atomic.Int64
+	bans             atomic.Int64
+	banSeconds       atomic.Int64
+	lastSuccess      atomic.Int64 // unix seconds
+	newestPosted     atomic.Int64 // unix seconds
+
+	mu       sync.Mutex
+	jobs     map[string]*jobMetrics
+	jobNames []string
+}
+
+type jobMetrics struct {
+	running     bool
+	runs        int64
+	failures    int64
+	lastSuccess int64
+	lastSeconds float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{jobs: make(map[string]*jobMetrics)}
+}
+
+func (m *Metrics) pageFetched() {
+	if m != nil {
+		m.pagesFetched.Add(1)
+	}
+}
+
+func (m *Metrics) pageRetried() {
+	if m != nil {
+		m.pageRetries.Add(1)
+	}
+}
+
+func (m *Metrics) apiRetried() {
+	if m != nil {
+		m.apiRetries.Add(1)
+	}
+}
+
+func (m *Metrics) apiBatch(ok bool) {
+	if m == nil {
+		return
+	}
+	if ok {
+		m.apiBatches.Add(1)
+	} else {
+		m.apiBatchFailures.Add(1)
+	}
+}
+
+func (m *Metrics) gallerySaved(inserted bool, posted int64) {
+	if m == nil {
+		return
+	}
+	if inserted {
+		m.galleriesNew.Add(1)
+	} else {
+		m.galleriesUpdated.Add(1)
+	}
+	m.seenPosted(posted)
+}
+
+// seenPosted raises the newest posted gauge to posted.
+func (m *Metrics) seenPosted(posted int64) {
+	if m == nil {
+		return
+	}
+	for {
+		cur := m.newestPosted.Load()
+		if posted <= cur || m.newestPosted.CompareAndSwap(cur, posted) {
+			return
+		}
+	}
+}
+
+func (m *Metrics) tagCreated() {
+	if m != nil {
+		m.tagsCreated.Add(1)
+	}
+}
+
+func (m *Metrics) banned(seconds int) {
+	if m == nil {
+		return
+	}
+	m.bans.Add(1)
+	m.banSeconds.Add(int64(seconds))
+}
+
+func (m *Metrics) syncSucceeded() {
+	if m != nil {
+		m.lastSuccess.Store(time.Now().Unix())
+	}
+}
+
+func (m *Metrics) job(name string) *jobMetrics {
+	j, ok := m.jobs[name]
+	if !ok {
+		j = &jobMetrics{}
+		m.jobs[name] = j
+		m.jobNames = append(m.jobNames, name)
+		sort.Strings(m.jobNames)
+	}
+	return j
+}
+
+func (m *Metrics) jobStarted(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.job(name).running = true
+}
+
+func (m *Metrics) jobFinished(name string, took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.job(name)
+	j.running = false
+	j.runs++
+	j.lastSeconds = took.Seconds()
+	if err != nil {
+		j.failures++
+	} else {
+		j.lastSuccess = time.Now().Unix()
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("ehsync_pages_fetched_total", "counter", "Site pages fetched (listings, gallery and torrent pages).", m.pagesFetched.Load())
+	metric("ehsync_page_retries_total", "counter", "Failed page fetch attempts that were retried.", m.pageRetries.Load())
+	metric("ehsync_api_batches_total", "counter", "gdata API batches fetched.", m.apiBatches.Load())
+	metric("ehsync_api_batch_failures_total", "counter", "gdata API batches given up on after all retries.", m.apiBatchFailures.Load())
+	metric("ehsync_api_retries_total", "counter", "Failed gdata API attempts that were retried.", m.apiRetries.Load())
+	fmt.Fprintf(cw, "# HELP ehsync_galleries_saved_total Galleries stored, by whether they were new or changed.\n# TYPE ehsync_galleries_saved_total counter\n")
+	fmt.Fprintf(cw, "ehsync_galleries_saved_total{result=\"inserted\"} %d\n", m.galleriesNew.Load())
+	fmt.Fprintf(cw, "ehsync_galleries_saved_total{result=\"updated\"} %d\n", m.galleriesUpdated.Load())
+	metric("ehsync_tags_created_total", "counter", "Tags added to the tag table.", m.tagsCreated.Load())
+	metric("ehsync_bans_total", "counter", "Ban pages encountered.", m.bans.Load())
+	metric("ehsync_ban_cooldown_seconds_total", "counter", "Seconds spent waiting out bans.", m.banSeconds.Load())
+	metric("ehsync_last_success_timestamp_seconds", "gauge", "Unix time the last sync pass finished without error.", m.lastSuccess.Load())
+	metric("ehsync_newest_posted_timestamp_seconds", "gauge", "Posted time of the newest gallery seen.", m.newestPosted.Load())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.jobNames) > 0 {
+		labeled := func(name, kind, help string, value func(j *jobMetrics) interface{}) {
+			fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+			for _, job := range m.jobNames {
+				fmt.Fprintf(cw, "%s{job=%q} %v\n", name, job, value(m.jobs[job]))
+			}
+		}
+		labeled("ehsync_job_running", "gauge", "Whether the daemon job is running.", func(j *jobMetrics) interface{} {
+			if j.running {
+				return 1
+			}
+			return 0
+		})
+		labeled("ehsync_job_runs_total", "counter", "Finished runs of the daemon job.", func(j *jobMetrics) interface{} { return j.runs })
+		labeled("ehsync_job_failures_total", "counter", "Failed runs of the daemon job.", func(j *jobMetrics) interface{} { return j.failures })
+		labeled("ehsync_job_last_success_timestamp_seconds", "gauge", "Unix time the daemon job last finished without error.", func(j *jobMetrics) interface{} { return j.lastSuccess })
+		labeled("ehsync_job_last_duration_seconds", "gauge", "Duration of the last run of the daemon job.", func(j *jobMetrics) interface{} { return j.lastSeconds })
+	}
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// ServeHTTP serves the metrics on /metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// writeFile dumps the metrics to path for the node_exporter textfile collector.
+// The file is replaced atomically so the collector never reads a partial file.
+func (m *Metrics) writeFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".metrics-*")
+	if err != nil {
+		return fmt.Errorf("writing metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := m.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing metrics: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("writing metrics: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing metrics: %w", err)
+	}
+	return nil
+}
+
+// initNewestPosted starts the newest posted gauge at the newest stored gallery.
+func (s *Sync) initNewestPosted() {
+	var posted int64
+	if err := s.db.QueryRow("SELECT posted FROM gallery ORDER BY posted DESC LIMIT 1").Scan(&posted); err == nil {
+		s.metrics.seenPosted(posted)
+	}
+}
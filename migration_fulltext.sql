@@ -0,0 +1,8 @@
+-- Full-text index on gallery titles for the search command.
+-- The ngram parser splits titles into character n-grams (ngram_token_size,
+-- default 2), so Japanese and Chinese titles without spaces are searchable too.
+-- InnoDB full-text indexes are maintained incrementally, so the table is
+-- converted first; on large tables both steps take a while.
+
+ALTER TABLE `gallery` ENGINE=InnoDB;
+ALTER TABLE `gallery` ADD FULLTEXT INDEX `ft_title` (`title`, `title_jpn`) WITH PARSER ngram;
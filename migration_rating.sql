@@ -0,0 +1,10 @@
+-- Store gallery.rating as a number instead of char(4).
+-- DECIMAL(3,2) keeps the two-decimal text form ("4.52") in mysqldump output,
+-- so existing consumers of the dumps keep working.
+
+UPDATE gallery
+SET rating = '0'
+WHERE rating NOT REGEXP '^[0-5](\\.[0-9]+)?$';
+
+ALTER TABLE gallery MODIFY COLUMN rating DECIMAL(3,2) NOT NULL DEFAULT 0;
+ALTER TABLE gallery ADD KEY rating (rating);
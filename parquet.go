@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// --- Parquet Export ---
+
+type parquetGallery struct {
+	Gid          int64     `parquet:"gid"`
+	Token        string    `parquet:"token"`
+	ArchiverKey  string    `parquet:"archiver_key"`
+	Title        string    `parquet:"title"`
+	TitleJpn     string    `parquet:"title_jpn"`
+	Category     string    `parquet:"category,dict"`
+	Thumb        string    `parquet:"thumb"`
+	Uploader     *string   `parquet:"uploader,optional,dict"`
+	Posted       time.Time `parquet:"posted,timestamp(millisecond)"`
+	Filecount    int32     `parquet:"filecount"`
+	Filesize     int64     `parquet:"filesize"`
+	Expunged     bool      `parquet:"expunged"`
+	Removed      bool      `parquet:"removed"`
+	Replaced     bool      `parquet:"replaced"`
+	Rating       float64   `parquet:"rating"`
+	Torrentcount int32     `parquet:"torrentcount"`
+	RootGid      *int64    `parquet:"root_gid,optional"`
+}
+
+type parquetTag struct {
+	Gid       int64  `parquet:"gid"`
+	Tag       string `parquet:"tag,dict"`
+	Namespace string `parquet:"namespace,dict"`
+	Value     string `parquet:"value,dict"`
+	// Translation is the translated name when the export asks for a language.
+	Translation *string `parquet:"translation,optional,dict"`
+}
+
+type parquetTorrent struct {
+	Gid   int64      `parquet:"gid"`
+	Name  string     `parquet:"name"`
+	Hash  string     `parquet:"hash"`
+	Added *time.Time `parquet:"added,optional,timestamp(millisecond)"`
+	Fsize int64      `parquet:"fsize"`
+}
+
+// maxOpenPartitions bounds how many posted-month partitions are written to at once.
+// Galleries are streamed in gid order, which follows posted closely, so only a handful
+// of months are active at any time; a month seen again after eviction gets a new part file.
+const maxOpenPartitions = 4
+
+type parquetPartition struct {
+	files     []*os.File
+	galleries *parquet.GenericWriter[parquetGallery]
+	tags      *parquet.GenericWriter[parquetTag]
+	torrents  *parquet.GenericWriter[parquetTorrent]
+	lastUsed  int
+}
+
+func (p *parquetPartition) close() error {
+	var firstErr error
+	for _, err := range []error{p.galleries.Close(), p.tags.Close(), p.torrents.Close()} {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, f := range p.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// parquetExporter writes galleries, tags and torrents into Hive-style
+// posted_month=YYYY-MM partitions below dir.
+type parquetExporter struct {
+	dir   string
+	open  map[string]*parquetPartition
+	parts map[string]int // number of part files created per month
+	tick  int
+}
+
+func newParquetExporter(dir string) *parquetExporter {
+	return &parquetExporter{
+		dir:   dir,
+		open:  make(map[string]*parquetPartition),
+		parts: make(map[string]int),
+	}
+}
+
+func (e *parquetExporter) partition(month string) (*parquetPartition, error) {
+	e.tick++
+	if p, ok := e.open[month]; ok {
+		p.lastUsed = e.tick
+		return p, nil
+	}
+	if len(e.open) >= maxOpenPartitions {
+		oldest := ""
+		for m, p := range e.open {
+			if oldest == "" || p.lastUsed < e.open[oldest].lastUsed {
+				oldest = m
+			}
+		}
+		if err := e.open[oldest].close(); err != nil {
+			return nil, fmt.Errorf("closing partition %s: %w", oldest, err)
+		}
+		delete(e.open, oldest)
+	}
+
+	part := e.parts[month]
+	e.parts[month]++
+	name := fmt.Sprintf("part-%05d.parquet", part)
+	p := &parquetPartition{lastUsed: e.tick}
+	create := func(table string) (*os.File, error) {
+		dir := filepath.Join(e.dir, table, "posted_month="+month)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		p.files = append(p.files, f)
+		return f, nil
+	}
+	opts := []parquet.WriterOption{parquet.Compression(&zstd.Codec{})}
+	gf, err := create("galleries")
+	if err != nil {
+		return nil, err
+	}
+	p.galleries = parquet.NewGenericWriter[parquetGallery](gf, opts...)
+	tf, err := create("tags")
+	if err != nil {
+		return nil, err
+	}
+	p.tags = parquet.NewGenericWriter[parquetTag](tf, opts...)
+	rf, err := create("torrents")
+	if err != nil {
+		return nil, err
+	}
+	p.torrents = parquet.NewGenericWriter[parquetTorrent](rf, opts...)
+	e.open[month] = p
+	return p, nil
+}
+
+func (e *parquetExporter) write(r *GalleryRecord) error {
+	postedAt := time.Unix(r.Posted, 0).UTC()
+	p, err := e.partition(postedAt.Format("2006-01"))
+	if err != nil {
+		return err
+	}
+
+	row := parquetGallery{
+		Gid:          int64(r.Gid),
+		Token:        r.Token,
+		ArchiverKey:  r.ArchiverKey,
+		Title:        r.Title,
+		TitleJpn:     r.TitleJpn,
+		Category:     r.Category,
+		Thumb:        r.Thumb,
+		Posted:       postedAt,
+		Filecount:    int32(r.Filecount),
+		Filesize:     int64(r.Filesize),
+		Expunged:     r.Expunged,
+		Removed:      r.Removed,
+		Replaced:     r.Replaced,
+		Rating:       float64(r.Rating),
+		Torrentcount: int32(r.Torrentcount),
+	}
+	if r.Uploader != "" {
+		uploader := r.Uploader
+		row.Uploader = &uploader
+	}
+	if r.ParentGid != "" {
+		if rootGid, err := strconv.ParseInt(r.ParentGid, 10, 64); err == nil {
+			row.RootGid = &rootGid
+		}
+	}
+	if _, err := p.galleries.Write([]parquetGallery{row}); err != nil {
+		return fmt.Errorf("writing gallery %d: %w", r.Gid, err)
+	}
+
+	if len(r.Tags) > 0 {
+		tags := make([]parquetTag, len(r.Tags))
+		for i, name := range r.Tags {
+			namespace, value := splitTag(name)
+			tags[i] = parquetTag{Gid: int64(r.Gid), Tag: name, Namespace: namespace, Value: value}
+			if translated, ok := r.TagTranslations[name]; ok {
+				tags[i].Translation = &translated
+			}
+		}
+		if _, err := p.tags.Write(tags); err != nil {
+			return fmt.Errorf("writing tags for gid %d: %w", r.Gid, err)
+		}
+	}
+
+	if len(r.Torrents) > 0 {
+		torrents := make([]parquetTorrent, len(r.Torrents))
+		for i, t := range r.Torrents {
+			torrents[i] = parquetTorrent{Gid: int64(r.Gid), Name: t.Name, Hash: t.Hash}
+			torrents[i].Fsize, _ = strconv.ParseInt(t.Fsize, 10, 64)
+			if added, err := strconv.ParseInt(t.Added, 10, 64); err == nil && added > 0 {
+				addedAt := time.Unix(added, 0).UTC()
+				torrents[i].Added = &addedAt
+			}
+		}
+		if _, err := p.torrents.Write(torrents); err != nil {
+			return fmt.Errorf("writing torrents for gid %d: %w", r.Gid, err)
+		}
+	}
+	return nil
+}
+
+func (e *parquetExporter) close() error {
+	var firstErr error
+	for month, p := range e.open {
+		if err := p.close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("closing partition %s: %w", month, err)
+		}
+	}
+	e.open = map[string]*parquetPartition{}
+	return firstErr
+}
+
+// exportParquet writes galleries, tags and torrents as Parquet files partitioned
+// by posted month below dir.
+func (st *Store) exportParquet(dir string, f ExportFilter) (int, error) {
+	e := newParquetExporter(dir)
+	count := 0
+	err := st.forEachGallery(f, func(r *GalleryRecord) error {
+		count++
+		if count%100000 == 0 {
+			debugLog("Exported %d galleries (last gid %d)", count, r.Gid)
+		}
+		return e.write(r)
+	})
+	if closeErr := e.close(); err == nil {
+		err = closeErr
+	}
+	return count, err
+}
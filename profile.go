@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Crawl Profiles ---
+
+// CrawlProfile is a named listing search from the profiles section of
+// config.yaml. Every profile keeps its own checkpoint in crawl_checkpoint, so
+// narrow searches can be crawled one after another without skipping galleries
+// the other profiles already passed.
+type CrawlProfile struct {
+	Name       string   `mapstructure:"name"`
+	Search     string   `mapstructure:"search"`     // f_search
+	Categories []string `mapstructure:"categories"` // categories to include; empty means all
+	Cats       int      `mapstructure:"f_cats"`     // raw bitmask of excluded categories, instead of categories
+	MinRating  int      `mapstructure:"min_rating"` // 2 to 5 stars
+	MinPages   int      `mapstructure:"min_pages"`
+	MaxPages   int      `mapstructure:"max_pages"`
+	Language   string   `mapstructure:"language"` // added to the search as language:<name>$
+	Expunged   bool     `mapstructure:"expunged"`
+	StartGid   int64    `mapstructure:"start_gid"` // where a profile without checkpoint starts
+}
+
+// categoryBits are the listing's f_cats bits; f_cats holds the excluded ones.
+var categoryBits = map[string]int{
+	"misc":       1,
+	"doujinshi":  2,
+	"manga":      4,
+	"artist cg":  8,
+	"game cg":    16,
+	"image set":  32,
+	"cosplay":    64,
+	"asian porn": 128,
+	"non-h":      256,
+	"western":    512,
+}
+
+const allCategoryBits = 1023
+
+// crawlCheckpointDDL creates crawl_checkpoint, the newest gid each crawl profile
+// has reached.
+var crawlCheckpointDDL = map[string][]string{
+	"mysql": {
+		"CREATE TABLE IF NOT EXISTS `crawl_checkpoint` (" +
+			"`profile` varchar(64) NOT NULL, " +
+			"`last_gid` int(11) NOT NULL, " +
+			"`updated_at` int(11) NOT NULL, " +
+			"PRIMARY KEY (`profile`)" +
+			") ENGINE=MyISAM DEFAULT CHARSET=utf8mb4",
+	},
+	"sqlite3": {
+		"CREATE TABLE IF NOT EXISTS crawl_checkpoint (profile TEXT PRIMARY KEY, last_gid INTEGER NOT NULL, updated_at INTEGER NOT NULL)",
+	},
+}
+
+// validate checks the settings and computes the category bitmask.
+func (p *CrawlProfile) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("profile without name")
+	}
+	if len(p.Name) > 64 {
+		return fmt.Errorf("profile %q: name longer than 64 characters", p.Name)
+	}
+	if len(p.Categories) > 0 {
+		if p.Cats != 0 {
+			return fmt.Errorf("profile %q: set either categories or f_cats", p.Name)
+		}
+		included := 0
+		for _, c := range p.Categories {
+			bit, ok := categoryBits[strings.ToLower(c)]
+			if !ok {
+				return fmt.Errorf("profile %q: unknown category %q", p.Name, c)
+			}
+			included |= bit
+		}
+		p.Cats = allCategoryBits &^ included
+	}
+	if p.Cats < 0 || p.Cats >= allCategoryBits {
+		return fmt.Errorf("profile %q: f_cats must be between 0 and %d", p.Name, allCategoryBits-1)
+	}
+	if p.MinRating != 0 && (p.MinRating < 2 || p.MinRating > 5) {
+		return fmt.Errorf("profile %q: min_rating must be between 2 and 5", p.Name)
+	}
+	if p.MinPages < 0 || p.MaxPages < 0 || p.MaxPages > 0 && p.MinPages > p.MaxPages {
+		return fmt.Errorf("profile %q: invalid page range %d-%d", p.Name, p.MinPages, p.MaxPages)
+	}
+	return nil
+}
+
+// searchText is f_search: the search with the language term added.
+func (p *CrawlProfile) searchText() string {
+	if p.Language == "" {
+		return p.Search
+	}
+	lang := "language:" + p.Language + "$"
+	if strings.Contains(p.Language, " ") {
+		lang = `language:"` + p.Language + `"$`
+	}
+	return strings.TrimSpace(p.Search + " " + lang)
+}
+
+// listingPath is the listing page at cursor, e.g. "prev=<gid>" for the galleries
+// newer than gid.
+func (p *CrawlProfile) listingPath(cursor string) string {
+	if cursor != "" {
+		cursor += "&"
+	}
+	path := fmt.Sprintf("/?%sf_cats=%d&advsearch=1&f_sname=on&f_ssearchs=on", cursor, p.Cats)
+	if p.Expunged {
+		path += "&f_sh=on"
+	}
+	if search := p.searchText(); search != "" {
+		path += "&f_search=" + url.QueryEscape(search)
+	}
+	if p.MinRating > 0 {
+		path += fmt.Sprintf("&f_sr=on&f_srdd=%d", p.MinRating)
+	}
+	var minPages, maxPages string
+	if p.MinPages > 0 || p.MaxPages > 0 {
+		path += "&f_sp=on"
+		if p.MinPages > 0 {
+			minPages = strconv.Itoa(p.MinPages)
+		}
+		if p.MaxPages > 0 {
+			maxPages = strconv.Itoa(p.MaxPages)
+		}
+	}
+	return path + "&f_spf=" + minPages + "&f_spt=" + maxPages + "&f_sft=on&f_sfu=on&f_sfl=on"
+}
+
+// loadCrawlProfiles returns the configured profiles with the given names, in
+// that order, or all of them in config order for "all".
+func loadCrawlProfiles(names []string) ([]*CrawlProfile, error) {
+	var all []*CrawlProfile
+	if err := viper.UnmarshalKey("profiles", &all); err != nil {
+		return nil, fmt.Errorf("reading profiles: %w", err)
+	}
+	byName := make(map[string]*CrawlProfile, len(all))
+	for _, p := range all {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		if byName[p.Name] != nil {
+			return nil, fmt.Errorf("profile %q is defined twice", p.Name)
+		}
+		byName[p.Name] = p
+	}
+	if len(names) == 1 && names[0] == "all" {
+		if len(all) == 0 {
+			return nil, fmt.Errorf("no profiles are configured")
+		}
+		return all, nil
+	}
+	var profiles []*CrawlProfile
+	for _, name := range names {
+		p := byName[name]
+		if p == nil {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// checkpoint returns the newest gid the profile has reached, if it ran before.
+func (st *Store) checkpoint(profile string) (int64, bool, error) {
+	var gid int64
+	err := st.db.QueryRow("SELECT last_gid FROM crawl_checkpoint WHERE profile = ?", profile).Scan(&gid)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("reading checkpoint of profile %s: %w", profile, err)
+	}
+	return gid, true, nil
+}
+
+func (st *Store) saveCheckpoint(profile string, gid int64) error {
+	_, err := st.db.Exec(st.upsertSQL("crawl_checkpoint", "profile", []string{"profile", "last_gid", "updated_at"}, []string{"last_gid", "updated_at"}),
+		profile, gid, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("saving checkpoint of profile %s: %w", profile, err)
+	}
+	return nil
+}
+
+// runProfiles crawls the selected profiles one after another, each from its
+// own checkpoint. A profile without checkpoint starts at its start_gid, or at
+// the newest stored gallery (the newest expunged one for expunged profiles).
+func (s *Sync) runProfiles() error {
+	if err := s.ensureTables(crawlCheckpointDDL); err != nil {
+		return err
+	}
+	var crawlErr error
+	for _, p := range s.profiles {
+		startGid, ok, err := s.checkpoint(p.Name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ok:
+		case p.StartGid > 0:
+			startGid = p.StartGid
+		case p.Expunged:
+			startGid, err = s.getLastExpungedGid()
+		default:
+			startGid, err = s.getLastGid()
+		}
+		if err != nil {
+			return err
+		}
+
+		fields := logFields{"profile", p.Name}
+		fields.infoLog("Starting profile %s with gid: %d", p.Name, startGid)
+		kind := "normal"
+		if p.Expunged {
+			kind = "expunged"
+		}
+		s.report.startPass(kind, p.Name, startGid)
+		err = s.crawl(p, "prev="+strconv.FormatInt(startGid, 10), nil, func(gid int64) {
+			if err := s.saveCheckpoint(p.Name, gid); err != nil {
+				fields.errorLog("Error: %v", err)
+			}
+		})
+		if err != nil {
+			crawlErr = errors.Join(crawlErr, fmt.Errorf("profile %s: %w", p.Name, err))
+		}
+	}
+	return crawlErr
+}
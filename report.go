@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- Run Report ---
+
+// RunReport summarizes one sync run for release notes and alerting. Like
+// Metrics, a nil *RunReport records nothing.
+type RunReport struct {
+	Job             string        `json:"job,omitempty"`
+	Site            string        `json:"site"`
+	StartedAt       time.Time     `json:"started_at"`
+	FinishedAt      time.Time     `json:"finished_at"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Passes          []*PassReport `json:"passes"`
+	Galleries       struct {
+		New      int `json:"new"`
+		Updated  int `json:"updated"`
+		Removed  int `json:"removed"`  // the API returned an error for the gid
+		Expunged int `json:"expunged"` // newly marked expunged
+	} `json:"galleries"`
+	RemovedGids   []int         `json:"removed_gids"`
+	FailedBatches []FailedBatch `json:"failed_batches"`
+	FailedPages   []FailedPage  `json:"failed_pages"`
+	Bans          int           `json:"bans"`
+	BanSeconds    int           `json:"ban_seconds"`
+	Error         string        `json:"error,omitempty"`
+	Database      *DBSummary    `json:"database,omitempty"`
+
+	mu sync.Mutex
+}
+
+// PassReport is the range of listings a normal or expunged pass went through.
+type PassReport struct {
+	Kind     string `json:"kind"`
+	Profile  string `json:"profile,omitempty"`
+	StartGid int64  `json:"start_gid"`
+	EndGid   int64  `json:"end_gid"` // newest gid the pass reached
+	Pages    int    `json:"pages"`
+}
+
+// FailedBatch is a gdata batch that could not be fetched.
+type FailedBatch struct {
+	Gids  []int  `json:"gids"`
+	Error string `json:"error"`
+}
+
+// FailedPage is a listing page that could not be fetched; the pass stops there.
+type FailedPage struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// DBSummary is the state of the database after a run.
+type DBSummary struct {
+	Galleries  int   `json:"galleries"`
+	LastGid    int64 `json:"last_gid"`
+	LastPosted int64 `json:"last_posted"`
+}
+
+func NewRunReport(site, job string) *RunReport {
+	return &RunReport{Job: job, Site: site, StartedAt: time.Now().UTC(), RemovedGids: []int{}, FailedBatches: []FailedBatch{}, FailedPages: []FailedPage{}}
+}
+
+func (r *RunReport) startPass(kind, profile string, startGid int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Passes = append(r.Passes, &PassReport{Kind: kind, Profile: profile, StartGid: startGid, EndGid: startGid})
+}
+
+// pageDone records a listing page of the current pass.
+func (r *RunReport) pageDone(entries []PageEntry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Passes) == 0 {
+		return
+	}
+	pass := r.Passes[len(r.Passes)-1]
+	pass.Pages++
+	for _, e := range entries {
+		if gid, err := strconv.ParseInt(e.GID, 10, 64); err == nil && gid > pass.EndGid {
+			pass.EndGid = gid
+		}
+	}
+}
+
+func (r *RunReport) batchFailed(entries []PageEntry, err error) {
+	if r == nil {
+		return
+	}
+	gids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		if gid, err := strconv.Atoi(e.GID); err == nil {
+			gids = append(gids, gid)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FailedBatches = append(r.FailedBatches, FailedBatch{Gids: gids, Error: err.Error()})
+}
+
+func (r *RunReport) pageFailed(url string, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FailedPages = append(r.FailedPages, FailedPage{URL: url, Error: err.Error()})
+}
+
+func (r *RunReport) galleriesRemoved(gids []int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Galleries.Removed += len(gids)
+	r.RemovedGids = append(r.RemovedGids, gids...)
+}
+
+func (r *RunReport) gallerySaved(inserted, expunged bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if inserted {
+		r.Galleries.New++
+	} else {
+		r.Galleries.Updated++
+	}
+	if expunged {
+		r.Galleries.Expunged++
+	}
+}
+
+func (r *RunReport) banned(seconds int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Bans++
+	r.BanSeconds += seconds
+}
+
+// finish stamps the end of the run and its outcome.
+func (r *RunReport) finish(runErr error, summary *DBSummary) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = time.Now().UTC()
+	r.DurationSeconds = r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond).Seconds()
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+	r.Database = summary
+}
+
+// write stores the report as JSON in path, or prints it to stdout for "-".
+func (r *RunReport) write(path string) error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
+
+// writeToDir stores the report in dir under a name made of the job and start time.
+func (r *RunReport) writeToDir(dir string) error {
+	name := fmt.Sprintf("%s-%s.json", r.Job, r.StartedAt.Format("20060102T150405Z"))
+	return r.write(filepath.Join(dir, name))
+}
+
+// databaseSummary returns the gallery count and the newest posted gallery.
+func (st *Store) databaseSummary() (*DBSummary, error) {
+	var sum DBSummary
+	if err := st.db.QueryRow("SELECT COUNT(*) FROM gallery").Scan(&sum.Galleries); err != nil {
+		return nil, fmt.Errorf("error retrieving total entry count: %w", err)
+	}
+	if err := st.db.QueryRow("SELECT gid, posted FROM gallery ORDER BY posted DESC LIMIT 1").Scan(&sum.LastGid, &sum.LastPosted); err != nil {
+		return nil, fmt.Errorf("error retrieving last posted gallery: %w", err)
+	}
+	return &sum, nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// --- Search Queries ---
+
+// namespaceAliases are the short namespace forms accepted by the site's search.
+var namespaceAliases = map[string]string{
+	"a":   "artist",
+	"c":   "character",
+	"cos": "cosplayer",
+	"f":   "female",
+	"g":   "group",
+	"l":   "language",
+	"m":   "male",
+	"o":   "other",
+	"p":   "parody",
+	"r":   "reclass",
+	"x":   "mixed",
+}
+
+// searchTerm is one term of a search query.
+type searchTerm struct {
+	Namespace string // tag namespace, "uploader", or "" for title words
+	Value     string
+	Exact     bool // trailing $: the whole tag must match, not only its prefix
+	Exclude   bool // leading -
+	Or        bool // leading ~: at least one of the ~ terms must match
+}
+
+// SearchQuery is a parsed e-hentai style search with the extra filters of the
+// search command.
+type SearchQuery struct {
+	Terms      []searchTerm
+	Categories []string
+	MinRating  float64
+	PostedFrom int64 // unix seconds, inclusive
+	PostedTo   int64 // unix seconds, exclusive
+	Expunged   bool  // include expunged galleries
+}
+
+// tokenizeSearch splits a query on spaces, keeping quoted parts together.
+func tokenizeSearch(q string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	inQuote := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			cur.WriteRune(r)
+		case r == ' ' && !inQuote:
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in %q", q)
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
+
+// parseSearchQuery parses the terms of a site search: `female:glasses$`,
+// `-language:chinese`, `f:"big breasts$"`, `~artist:a ~artist:b`,
+// `uploader:name`, "title words" and bare title words.
+func parseSearchQuery(q string) ([]searchTerm, error) {
+	tokens, err := tokenizeSearch(q)
+	if err != nil {
+		return nil, err
+	}
+	var terms []searchTerm
+	for _, tok := range tokens {
+		var t searchTerm
+		switch tok[0] {
+		case '-':
+			t.Exclude = true
+			tok = tok[1:]
+		case '~':
+			t.Or = true
+			tok = tok[1:]
+		}
+		switch {
+		case strings.HasSuffix(tok, "$"):
+			t.Exact = true
+			tok = strings.TrimSuffix(tok, "$")
+		case strings.HasSuffix(tok, `$"`):
+			// The site's own form for quoted tags: f:"big breasts$"
+			t.Exact = true
+			tok = strings.TrimSuffix(tok, `$"`) + `"`
+		}
+		if i := strings.Index(tok, ":"); i > 0 && !strings.Contains(tok[:i], `"`) {
+			t.Namespace = strings.ToLower(tok[:i])
+			if full, ok := namespaceAliases[t.Namespace]; ok {
+				t.Namespace = full
+			}
+			tok = tok[i+1:]
+		}
+		t.Value = strings.TrimSpace(strings.ReplaceAll(tok, `"`, ""))
+		if t.Value == "" {
+			continue
+		}
+		if t.Namespace == "" && t.Exact {
+			// A bare term with $ names a tag in any namespace.
+			t.Namespace = "*"
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+// escapeLike escapes LIKE wildcards for use with ESCAPE '!'.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// titleCondition matches galleries whose title or Japanese title contains text,
+// through the full-text index when there is one.
+func (st *Store) titleCondition(text string) (string, []interface{}) {
+	if cond, args, ok := st.fullTextCondition(text); ok {
+		return cond, args
+	}
+	pattern := "%" + escapeLike(text) + "%"
+	return "(g.title LIKE ? ESCAPE '!' OR g.title_jpn LIKE ? ESCAPE '!')", []interface{}{pattern, pattern}
+}
+
+// termCondition translates a single term into a condition on gallery g.
+func (st *Store) termCondition(t searchTerm) (string, []interface{}) {
+	switch t.Namespace {
+	case "":
+		return st.titleCondition(t.Value)
+	case "uploader":
+		return "g.uploader = ?", []interface{}{t.Value}
+	}
+	var conds []string
+	var args []interface{}
+	if t.Namespace != "*" {
+		conds = append(conds, "t.namespace = ?")
+		args = append(args, t.Namespace)
+	}
+	if t.Exact {
+		conds = append(conds, "t.value = ?")
+		args = append(args, t.Value)
+	} else {
+		conds = append(conds, "t.value LIKE ? ESCAPE '!'")
+		args = append(args, escapeLike(t.Value)+"%")
+	}
+	return "g.gid IN (SELECT gt.gid FROM gid_tid gt JOIN tag t ON t.id = gt.tid WHERE " + strings.Join(conds, " AND ") + ")", args
+}
+
+// searchWhere builds the WHERE clause (without the keyword) and its arguments.
+func (st *Store) searchWhere(q SearchQuery) (string, []interface{}) {
+	var conds, ors []string
+	var args, orArgs []interface{}
+	for _, t := range q.Terms {
+		cond, condArgs := st.termCondition(t)
+		switch {
+		case t.Or:
+			ors = append(ors, cond)
+			orArgs = append(orArgs, condArgs...)
+			continue
+		case t.Exclude:
+			cond = "NOT " + cond
+		}
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	if len(ors) > 0 {
+		conds = append(conds, "("+strings.Join(ors, " OR ")+")")
+		args = append(args, orArgs...)
+	}
+	if len(q.Categories) > 0 {
+		conds = append(conds, "g.category IN ("+placeholders(len(q.Categories))+")")
+		for _, c := range q.Categories {
+			args = append(args, c)
+		}
+	}
+	if q.MinRating > 0 {
+		conds = append(conds, "g.rating >= ?")
+		args = append(args, q.MinRating)
+	}
+	if q.PostedFrom > 0 {
+		conds = append(conds, "g.posted >= ?")
+		args = append(args, q.PostedFrom)
+	}
+	if q.PostedTo > 0 {
+		conds = append(conds, "g.posted < ?")
+		args = append(args, q.PostedTo)
+	}
+	if !q.Expunged {
+		conds = append(conds, "g.expunged = 0")
+	}
+	if len(conds) == 0 {
+		return "1=1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// matches reports whether a gallery satisfies the query, with the semantics of
+// searchWhere but in memory, e.g. for galleries that are being imported. Tags
+// are expected in canonical form.
+func (q SearchQuery) matches(g GalleryMetadata) bool {
+	hasOr, matchedOr := false, false
+	for _, t := range q.Terms {
+		ok := t.matches(g)
+		switch {
+		case t.Or:
+			hasOr = true
+			matchedOr = matchedOr || ok
+		case t.Exclude:
+			if ok {
+				return false
+			}
+		case !ok:
+			return false
+		}
+	}
+	if hasOr && !matchedOr {
+		return false
+	}
+	if len(q.Categories) > 0 {
+		found := false
+		for _, c := range q.Categories {
+			found = found || strings.EqualFold(c, g.Category)
+		}
+		if !found {
+			return false
+		}
+	}
+	switch {
+	case q.MinRating > 0 && float64(g.Rating) < q.MinRating:
+		return false
+	case q.PostedFrom > 0 && g.Posted < q.PostedFrom:
+		return false
+	case q.PostedTo > 0 && g.Posted >= q.PostedTo:
+		return false
+	case !q.Expunged && g.Expunged:
+		return false
+	}
+	return true
+}
+
+// matches is the in-memory counterpart of termCondition. Like LIKE on both
+// databases, comparisons ignore case.
+func (t searchTerm) matches(g GalleryMetadata) bool {
+	value := strings.ToLower(t.Value)
+	switch t.Namespace {
+	case "":
+		return strings.Contains(strings.ToLower(g.Title), value) || strings.Contains(strings.ToLower(g.TitleJpn), value)
+	case "uploader":
+		return strings.EqualFold(g.Uploader, t.Value)
+	}
+	for _, name := range g.Tags {
+		namespace, tag := splitTag(name)
+		if t.Namespace != "*" && namespace != t.Namespace {
+			continue
+		}
+		tag = strings.ToLower(tag)
+		if tag == value || !t.Exact && strings.HasPrefix(tag, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchGalleries returns up to limit matching galleries with their tags and
+// torrents, newest first (or best rated first when byRating is set).
+func (st *Store) searchGalleries(q SearchQuery, byRating bool, limit, offset int) ([]*GalleryRecord, error) {
+	where, args := st.searchWhere(q)
+	order := "g.gid DESC"
+	if byRating {
+		order = "g.rating DESC, g.gid DESC"
+	}
+	rows, err := st.db.Query("SELECT g.gid FROM gallery g WHERE "+where+" ORDER BY "+order+" LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("searching galleries: %w", err)
+	}
+	var gids []int
+	for rows.Next() {
+		var gid int
+		if err := rows.Scan(&gid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning gallery: %w", err)
+		}
+		gids = append(gids, gid)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading search results: %w", err)
+	}
+
+	records, err := st.loadGalleryRecords(gids)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*GalleryRecord, 0, len(gids))
+	for _, gid := range gids {
+		if r := records[gid]; r != nil {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// --- Search Command ---
+
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	query := fs.String("query", "", "Search query; may also be given after the flags (use -- before terms starting with '-')")
+	category := fs.String("category", "", "Only galleries in these categories (comma separated, e.g. 'Doujinshi,Manga')")
+	minRating := fs.Float64("min-rating", 0, "Only galleries rated at least this")
+	postedFrom := fs.String("posted-from", "", "Only galleries posted on or after this date (YYYY-MM-DD, UTC)")
+	postedTo := fs.String("posted-to", "", "Only galleries posted before this date (YYYY-MM-DD, UTC)")
+	expunged := fs.Bool("expunged", false, "Include expunged galleries")
+	sortBy := fs.String("sort", "gid", "Result order: 'gid' (newest first) or 'rating'")
+	limit := fs.Int("limit", 25, "Maximum number of results")
+	offset := fs.Int("offset", 0, "Number of results to skip")
+	lang := fs.String("lang", "", "Include tag translations in this language (see import-translations)")
+	asJSON := fs.Bool("json", false, "Print the results as JSON")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	if *asJSON {
+		logToStderr()
+	}
+	terms, err := parseSearchQuery(strings.TrimSpace(*query + " " + strings.Join(fs.Args(), " ")))
+	if err != nil {
+		errorLog("%v", err)
+		os.Exit(1)
+	}
+	q := SearchQuery{Terms: terms, MinRating: *minRating, Expunged: *expunged}
+	for _, c := range strings.Split(*category, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			q.Categories = append(q.Categories, c)
+		}
+	}
+	if q.PostedFrom, err = parseDateFlag("posted-from", *postedFrom); err != nil {
+		errorLog("%v", err)
+		os.Exit(1)
+	}
+	if q.PostedTo, err = parseDateFlag("posted-to", *postedTo); err != nil {
+		errorLog("%v", err)
+		os.Exit(1)
+	}
+	if *sortBy != "gid" && *sortBy != "rating" {
+		errorLog("Unsupported sort order: %s", *sortBy)
+		os.Exit(1)
+	}
+
+	st := openExportStore(ExportFilter{TagLang: *lang})
+	results, err := st.searchGalleries(q, *sortBy == "rating", *limit, *offset)
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	if *lang != "" && len(results) > 0 {
+		if err := st.attachTagTranslations(results, *lang); err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	if len(results) == 0 {
+		infoLog("No galleries found")
+		return
+	}
+	data := pterm.TableData{{"GID", "Posted (UTC)", "Category", "Rating", "Title", "Tags"}}
+	for _, r := range results {
+		tags := make([]string, len(r.Tags))
+		for i, name := range r.Tags {
+			tags[i] = name
+			if translated, ok := r.TagTranslations[name]; ok {
+				tags[i] = translated
+			}
+		}
+		data = append(data, []string{
+			fmt.Sprint(r.Gid),
+			time.Unix(r.Posted, 0).UTC().Format("2006-01-02 15:04"),
+			r.Category,
+			r.Rating.String(),
+			r.Title,
+			strings.Join(tags, ", "),
+		})
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+	infoLog("%d galleries", len(results))
+}
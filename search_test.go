@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []searchTerm
+	}{
+		{"", nil},
+		{"female:glasses$", []searchTerm{{Namespace: "female", Value: "glasses", Exact: true}}},
+		{"f:glasses", []searchTerm{{Namespace: "female", Value: "glasses"}}},
+		{`female:"big breasts$"`, []searchTerm{{Namespace: "female", Value: "big breasts", Exact: true}}},
+		{`female:"big breasts"$`, []searchTerm{{Namespace: "female", Value: "big breasts", Exact: true}}},
+		{`f:"big breasts"`, []searchTerm{{Namespace: "female", Value: "big breasts"}}},
+		{"-language:chinese", []searchTerm{{Namespace: "language", Value: "chinese", Exclude: true}}},
+		{"~a:foo ~a:bar", []searchTerm{
+			{Namespace: "artist", Value: "foo", Or: true},
+			{Namespace: "artist", Value: "bar", Or: true},
+		}},
+		{"uploader:Someone", []searchTerm{{Namespace: "uploader", Value: "Someone"}}},
+		{"glasses$", []searchTerm{{Namespace: "*", Value: "glasses", Exact: true}}},
+		{`"my title" word`, []searchTerm{{Value: "my title"}, {Value: "word"}}},
+		{`"a:b c"`, []searchTerm{{Value: "a:b c"}}},
+		{"Female:glasses", []searchTerm{{Namespace: "female", Value: "glasses"}}},
+		{`f:""  -`, nil},
+	}
+	for _, tt := range tests {
+		got, err := parseSearchQuery(tt.query)
+		if err != nil {
+			t.Errorf("parseSearchQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchQueryUnterminatedQuote(t *testing.T) {
+	if _, err := parseSearchQuery(`female:"big breasts`); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func TestSearchQueryMatches(t *testing.T) {
+	g := GalleryMetadata{
+		Title:    "Some Title",
+		Category: "Doujinshi",
+		Uploader: "alice",
+		Tags:     []string{"female:big breasts", "female:glasses", "language:english"},
+	}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{`female:"big breasts$"`, true},
+		{`female:"big breast$"`, false},
+		{`female:"big breast"`, true},
+		{"f:glasses$ -language:chinese", true},
+		{"-language:english", false},
+		{"~language:chinese ~language:english", true},
+		{"~language:chinese ~language:korean", false},
+		{"title", true},
+		{"uploader:ALICE", true},
+		{"english$", true},
+	}
+	for _, tt := range tests {
+		terms, err := parseSearchQuery(tt.query)
+		if err != nil {
+			t.Fatalf("parseSearchQuery(%q): %v", tt.query, err)
+		}
+		if got := (SearchQuery{Terms: terms}).matches(g); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
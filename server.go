@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- Query API Server ---
+
+// TorrentRecord is a stored torrent together with the data scraped from the
+// torrent page, if any.
+type TorrentRecord struct {
+	Gid int `json:"gid"`
+	TorrentInfo
+}
+
+// TagSuggestion is one result of tag autocompletion.
+type TagSuggestion struct {
+	Tag         string `json:"tag"`
+	Namespace   string `json:"namespace"`
+	Value       string `json:"value"`
+	Translation string `json:"translation,omitempty"`
+}
+
+// Stats summarizes the database.
+type Stats struct {
+	Galleries  int            `json:"galleries"`
+	Expunged   int            `json:"expunged"`
+	Tags       int            `json:"tags"`
+	Torrents   int            `json:"torrents"`
+	LastGid    int            `json:"last_gid"`
+	LastPosted int64          `json:"last_posted"`
+	Categories map[string]int `json:"categories"`
+}
+
+func (st *Store) torrents(column string, value interface{}) ([]TorrentRecord, error) {
+	rows, err := st.db.Query("SELECT "+st.torrentColumns()+" FROM torrent WHERE "+column+" = ? ORDER BY gid, added", value)
+	if err != nil {
+		return nil, fmt.Errorf("querying torrents: %w", err)
+	}
+	defer rows.Close()
+	list := []TorrentRecord{}
+	for rows.Next() {
+		gid, t, err := scanTorrent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning torrent: %w", err)
+		}
+		list = append(list, TorrentRecord{Gid: gid, TorrentInfo: t})
+	}
+	return list, rows.Err()
+}
+
+// suggestTags returns tags starting with prefix ("female:gla" or "gla"). A
+// namespace before the colon must match exactly; short forms are accepted.
+func (st *Store) suggestTags(prefix, lang string, limit int) ([]TagSuggestion, error) {
+	var conds []string
+	var args []interface{}
+	value := prefix
+	if i := strings.Index(prefix, ":"); i > 0 {
+		namespace := strings.ToLower(prefix[:i])
+		if full, ok := namespaceAliases[namespace]; ok {
+			namespace = full
+		}
+		conds = append(conds, "t.namespace = ?")
+		args = append(args, namespace)
+		value = prefix[i+1:]
+	}
+	conds = append(conds, "t.value LIKE ? ESCAPE '!'")
+	args = append(args, escapeLike(value)+"%")
+
+	query := "SELECT t.name, t.namespace, t.value, '' FROM tag t"
+	if lang != "" && st.hasTranslations {
+		query = "SELECT t.name, t.namespace, t.value, COALESCE(tt.name, '') FROM tag t LEFT JOIN tag_translation tt ON tt.tid = t.id AND tt.lang = ?"
+		args = append([]interface{}{lang}, args...)
+	}
+	rows, err := st.db.Query(query+" WHERE "+strings.Join(conds, " AND ")+" ORDER BY t.value, t.namespace LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying tags: %w", err)
+	}
+	defer rows.Close()
+	list := []TagSuggestion{}
+	for rows.Next() {
+		var s TagSuggestion
+		if err := rows.Scan(&s.Tag, &s.Namespace, &s.Value, &s.Translation); err != nil {
+			return nil, fmt.Errorf("scanning tag: %w", err)
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (st *Store) stats() (*Stats, error) {
+	s := &Stats{Categories: map[string]int{}}
+	var lastGid sql.NullInt64
+	var lastPosted sql.NullInt64
+	queries := []struct {
+		query string
+		dest  []interface{}
+	}{
+		{"SELECT COUNT(*), COALESCE(SUM(expunged), 0), MAX(gid), MAX(posted) FROM gallery", []interface{}{&s.Galleries, &s.Expunged, &lastGid, &lastPosted}},
+		{"SELECT COUNT(*) FROM tag", []interface{}{&s.Tags}},
+		{"SELECT COUNT(*) FROM torrent", []interface{}{&s.Torrents}},
+	}
+	for _, q := range queries {
+		if err := st.db.QueryRow(q.query).Scan(q.dest...); err != nil {
+			return nil, fmt.Errorf("querying stats: %w", err)
+		}
+	}
+	s.LastGid = int(lastGid.Int64)
+	s.LastPosted = lastPosted.Int64
+
+	rows, err := st.db.Query("SELECT category, COUNT(*) FROM gallery GROUP BY category")
+	if err != nil {
+		return nil, fmt.Errorf("querying categories: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		var n int
+		if err := rows.Scan(&category, &n); err != nil {
+			return nil, fmt.Errorf("scanning category: %w", err)
+		}
+		s.Categories[category] = n
+	}
+	return s, rows.Err()
+}
+
+// Server serves read-only JSON queries against the store.
+type Server struct {
+	st   *Store
+	host string // site host used for gallery links in feeds
+
+	// Stats scan the whole gallery table, so they are cached briefly.
+	statsMu      sync.Mutex
+	statsCache   *Stats
+	statsExpires time.Time
+}
+
+const (
+	defaultPageSize = 25
+	maxPageSize     = 100
+	statsCacheTTL   = time.Minute
+)
+
+func NewServer(st *Store) *Server {
+	return &Server{st: st, host: siteHost("e-hentai")}
+}
+
+func (srv *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/galleries/{gid}", srv.handleGallery)
+	mux.HandleFunc("GET /api/galleries/{gid}/torrents", srv.handleGalleryTorrents)
+	mux.HandleFunc("GET /api/search", srv.handleSearch)
+	mux.HandleFunc("GET /api/torrents/{hash}", srv.handleTorrent)
+	mux.HandleFunc("GET /api/tags", srv.handleTags)
+	mux.HandleFunc("GET /api/stats", srv.handleStats)
+	mux.HandleFunc("POST /api.php", srv.handleAPI)
+	mux.HandleFunc("GET /feeds/galleries.atom", srv.handleGalleryFeed)
+	mux.HandleFunc("GET /feeds/expunged.atom", srv.handleExpungedFeed)
+	return logRequests(mux)
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		debugLog("%s %s (%s)", r.Method, r.URL.RequestURI(), time.Since(start).Round(time.Millisecond))
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, a ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, a...)})
+}
+
+// internalError logs err and answers with a generic message, so database
+// details don't leak to clients.
+func internalError(w http.ResponseWriter, err error) {
+	errorLog("Error serving request: %v", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// intParam parses an optional integer query parameter.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+func pageSize(r *http.Request) (int, error) {
+	limit, err := intParam(r, "limit", defaultPageSize)
+	if err != nil {
+		return 0, err
+	}
+	if limit == 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
+
+func (srv *Server) handleGallery(w http.ResponseWriter, r *http.Request) {
+	gid, err := strconv.Atoi(r.PathValue("gid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid gid %q", r.PathValue("gid"))
+		return
+	}
+	records, err := srv.st.loadGalleryRecords([]int{gid})
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	record := records[gid]
+	if record == nil {
+		writeError(w, http.StatusNotFound, "gallery %d not found", gid)
+		return
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		if err := srv.st.attachTagTranslations([]*GalleryRecord{record}, lang); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+func (srv *Server) handleGalleryTorrents(w http.ResponseWriter, r *http.Request) {
+	gid, err := strconv.Atoi(r.PathValue("gid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid gid %q", r.PathValue("gid"))
+		return
+	}
+	list, err := srv.st.torrents("gid", gid)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (srv *Server) handleTorrent(w http.ResponseWriter, r *http.Request) {
+	hash := strings.ToLower(r.PathValue("hash"))
+	list, err := srv.st.torrents("hash", hash)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if len(list) == 0 {
+		writeError(w, http.StatusNotFound, "torrent %s not found", hash)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// searchParams reads a site-style query from q, plus tag, title and uploader
+// parameters that are added as terms, and the filters of the search command.
+func searchParams(params url.Values) (SearchQuery, error) {
+	query := params.Get("q")
+	for _, tag := range params["tag"] {
+		tag = strings.ReplaceAll(tag, `"`, "")
+		if i := strings.Index(tag, ":"); i > 0 {
+			query += " " + tag[:i+1] + `"` + tag[i+1:] + `"$`
+		} else {
+			query += ` "` + tag + `"$`
+		}
+	}
+	for _, title := range params["title"] {
+		query += ` "` + strings.ReplaceAll(title, `"`, "") + `"`
+	}
+	for _, uploader := range params["uploader"] {
+		query += ` uploader:"` + strings.ReplaceAll(uploader, `"`, "") + `"`
+	}
+	terms, err := parseSearchQuery(query)
+	if err != nil {
+		return SearchQuery{}, err
+	}
+	q := SearchQuery{Terms: terms, Categories: params["category"], Expunged: params.Get("expunged") == "1" || params.Get("expunged") == "true"}
+	if v := params.Get("min_rating"); v != "" {
+		if q.MinRating, err = strconv.ParseFloat(v, 64); err != nil {
+			return SearchQuery{}, fmt.Errorf("invalid min_rating %q", v)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"posted_from", &q.PostedFrom}, {"posted_to", &q.PostedTo}} {
+		if *p.dst, err = parseDateFlag(p.name, params.Get(p.name)); err != nil {
+			return SearchQuery{}, fmt.Errorf("invalid %s %q, expected YYYY-MM-DD", p.name, params.Get(p.name))
+		}
+	}
+	return q, nil
+}
+
+// handleSearch answers searches with the parameters of searchParams plus
+// sort, limit, offset and lang.
+func (srv *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q, err := searchParams(params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	sortBy := params.Get("sort")
+	if sortBy != "" && sortBy != "gid" && sortBy != "rating" {
+		writeError(w, http.StatusBadRequest, "invalid sort %q", sortBy)
+		return
+	}
+	limit, err := pageSize(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	results, err := srv.st.searchGalleries(q, sortBy == "rating", limit, offset)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if lang := params.Get("lang"); lang != "" && len(results) > 0 {
+		if err := srv.st.attachTagTranslations(results, lang); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (srv *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSpace(r.URL.Query().Get("q"))
+	if prefix == "" {
+		writeError(w, http.StatusBadRequest, "missing q")
+		return
+	}
+	limit, err := intParam(r, "limit", 10)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if limit == 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	list, err := srv.st.suggestTags(prefix, r.URL.Query().Get("lang"), limit)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (srv *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	srv.statsMu.Lock()
+	defer srv.statsMu.Unlock()
+	if srv.statsCache == nil || time.Now().After(srv.statsExpires) {
+		s, err := srv.st.stats()
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		srv.statsCache = s
+		srv.statsExpires = time.Now().Add(statsCacheTTL)
+	}
+	writeJSON(w, http.StatusOK, srv.statsCache)
+}
+
+// --- Serve Command ---
+
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "Address to listen on")
+	site := fs.String("site", "e-hentai", "Site used for gallery links in feeds: 'e-hentai' or 'exhentai'")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	st := openReadOnlyStore()
+	if !st.hasTranslations {
+		infoLog("The database has no tag_translation table; tag translations are not served")
+	}
+
+	srv := NewServer(st)
+	srv.host = siteHost(*site)
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      60 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	infoLog("Serving the query API on http://%s/api/", *listen)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+}
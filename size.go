@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// --- Size Parsing ---
+
+// ByteSize is a parsed file size. Human readable sizes such as "1.23 GiB" are only
+// accurate to their last digit, so Min and Max bound the real value the same way
+// migration.sql does for fsize_min/fsize_max.
+type ByteSize struct {
+	Bytes int64 // best estimate (exact for plain byte counts)
+	Min   int64
+	Max   int64
+	Exact bool
+}
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"pb":  1000 * 1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+var sizeRe = regexp.MustCompile(`^(\d+)(?:\.(\d+))?\s*([a-zA-Z]*)$`)
+
+// parseByteSize parses sizes as returned by the API ("51210504") or shown on
+// site pages ("1.23 GiB", "512 KB", "1,024.5 MiB"). Decimal (KB, MB, ...) and
+// binary (KiB, MiB, ...) units are supported.
+func parseByteSize(s string) (ByteSize, error) {
+	clean := strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	m := sizeRe.FindStringSubmatch(clean)
+	if m == nil {
+		return ByteSize{}, fmt.Errorf("invalid size %q", s)
+	}
+	mult, ok := sizeUnits[strings.ToLower(m[3])]
+	if !ok {
+		return ByteSize{}, fmt.Errorf("unknown size unit %q in %q", m[3], s)
+	}
+
+	// The value as an integer n scaled by 10^decimals, so that bounds can be
+	// computed exactly: value ± 0.5 * 10^-decimals.
+	decimals := len(m[2])
+	n, ok := new(big.Int).SetString(m[1]+m[2], 10)
+	if !ok {
+		return ByteSize{}, fmt.Errorf("invalid size %q", s)
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	bigMult := big.NewInt(mult)
+
+	if mult == 1 && decimals == 0 {
+		if !n.IsInt64() {
+			return ByteSize{}, fmt.Errorf("size %q out of range", s)
+		}
+		b := n.Int64()
+		return ByteSize{Bytes: b, Min: b, Max: b, Exact: true}, nil
+	}
+
+	// round(num / den) for num >= 0, halves rounded up like MySQL's ROUND.
+	round := func(num, den *big.Int) (int64, error) {
+		q := new(big.Int).Mul(num, big.NewInt(2))
+		q.Add(q, den)
+		q.Quo(q, new(big.Int).Mul(den, big.NewInt(2)))
+		if !q.IsInt64() {
+			return 0, fmt.Errorf("size %q out of range", s)
+		}
+		return q.Int64(), nil
+	}
+
+	var size ByteSize
+	var err error
+	if size.Bytes, err = round(new(big.Int).Mul(n, bigMult), scale); err != nil {
+		return ByteSize{}, err
+	}
+	twice := new(big.Int).Mul(n, big.NewInt(2))
+	den := new(big.Int).Mul(scale, big.NewInt(2))
+	lower := new(big.Int).Sub(twice, big.NewInt(1))
+	if lower.Sign() < 0 {
+		lower.SetInt64(0)
+	}
+	if size.Min, err = round(lower.Mul(lower, bigMult), den); err != nil {
+		return ByteSize{}, err
+	}
+	upper := new(big.Int).Add(twice, big.NewInt(1))
+	if size.Max, err = round(upper.Mul(upper, bigMult), den); err != nil {
+		return ByteSize{}, err
+	}
+	return size, nil
+}
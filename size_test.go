@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ByteSize
+	}{
+		{"51210504", ByteSize{Bytes: 51210504, Min: 51210504, Max: 51210504, Exact: true}},
+		{" 12 B ", ByteSize{Bytes: 12, Min: 12, Max: 12, Exact: true}},
+		{"512 KB", ByteSize{Bytes: 512000, Min: 511500, Max: 512500}},
+		{"1kib", ByteSize{Bytes: 1024, Min: 512, Max: 1536}},
+		{"0 KB", ByteSize{Bytes: 0, Min: 0, Max: 500}},
+		{"0.5 KiB", ByteSize{Bytes: 512, Min: 461, Max: 563}},
+		{"1.23 GiB", ByteSize{Bytes: 1320702444, Min: 1315333734, Max: 1326071153}},
+		{"1,024.5 MiB", ByteSize{Bytes: 1074266112, Min: 1074213683, Max: 1074318541}},
+		// Halves round up, like MySQL's ROUND, rather than to even.
+		{"1.5 B", ByteSize{Bytes: 2, Min: 1, Max: 2}},
+		{"2.5 B", ByteSize{Bytes: 3, Min: 2, Max: 3}},
+		{"0.0005 KB", ByteSize{Bytes: 1, Min: 0, Max: 1}},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if err != nil {
+			t.Errorf("parseByteSize(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseByteSizeErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"abc",
+		"-1",
+		"1.2.3 GB",
+		"5 XB",
+		"GB",
+		"99999999999999999999",
+		"99999999 PB",
+	} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q): expected an error", in)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- Store ---
+
+// Store wraps the database handle shared by the importer and the
+// read-only commands such as export.
+type Store struct {
+	db     *sql.DB
+	driver string // normalized driver name: "mysql" or "sqlite3"
+	// fullTextMin is the shortest title search term the full-text index can
+	// match; 0 when the database has no usable index (see detectFullText).
+	fullTextMin int
+	metrics     *Metrics // nil unless the command exports metrics
+
+	// Optional tables and columns found by detectSchema. Read-only commands
+	// don't migrate the database, so they leave out what it lacks.
+	hasTorrentSizes  bool // torrent.fsize and tsize
+	hasTorrentStats  bool // torrent.seeders, leechers and downloads
+	hasGalleryDetail bool // the enrichment columns of gallery
+	hasTagAliases    bool
+	hasTranslations  bool
+	hasChangeLog     bool
+	hasHistory       bool // gallery_history, written by sync --history
+}
+
+// openStore establishes the database connection based on configuration.
+func openStore(config Config) *Store {
+	driver := config.DBDriver
+	if driver == "" {
+		driver = "mysql"
+	}
+
+	var (
+		db  *sql.DB
+		err error
+	)
+
+	switch strings.ToLower(driver) {
+	case "sqlite", "sqlite3":
+		driver = "sqlite3"
+		sqlitePath := config.SQLitePath
+		if sqlitePath == "" {
+			sqlitePath = config.DBName
+		}
+		if sqlitePath == "" {
+			errorLog("SQLite path must be provided via config or --sqlite-path when using sqlite driver")
+			os.Exit(1)
+		}
+		db, err = sql.Open("sqlite3", sqlitePath)
+	case "mysql":
+		driver = "mysql"
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?timeout=10s",
+			config.DBUser, config.DBPass, config.DBHost, config.DBPort, config.DBName)
+		db, err = sql.Open("mysql", dsn)
+	default:
+		errorLog("Unsupported database driver: %s", driver)
+		os.Exit(1)
+	}
+	if err != nil {
+		errorLog("Error opening DB: %v", err)
+		os.Exit(1)
+	}
+	if err = db.Ping(); err != nil {
+		errorLog("Error pinging DB: %v", err)
+		os.Exit(1)
+	}
+	return &Store{db: db, driver: driver}
+}
+
+func (st *Store) isSQLite() bool {
+	return st.driver == "sqlite3"
+}
+
+// unixTimeExpr returns an expression converting a DATETIME column to unix seconds.
+func (st *Store) unixTimeExpr(column string) string {
+	if st.isSQLite() {
+		return fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER)", column)
+	}
+	return fmt.Sprintf("UNIX_TIMESTAMP(%s)", column)
+}
+
+// placeholders returns n comma separated "?" placeholders.
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// fromUnixTimeExpr returns an expression converting a unix seconds placeholder to DATETIME.
+func (st *Store) fromUnixTimeExpr() string {
+	if st.isSQLite() {
+		return "datetime(?, 'unixepoch')"
+	}
+	return "FROM_UNIXTIME(?)"
+}
+
+// upsertSQL builds an INSERT into table that updates the given columns when
+// a row with the same key already exists.
+func (st *Store) upsertSQL(table, key string, columns, update []string) string {
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders(len(columns)))
+	sets := make([]string, len(update))
+	if st.isSQLite() {
+		for i, c := range update {
+			sets[i] = c + "=excluded." + c
+		}
+		return query + " ON CONFLICT(" + key + ") DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+	for i, c := range update {
+		sets[i] = c + "=VALUES(" + c + ")"
+	}
+	return query + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+// isDuplicateErr reports whether err is a unique key violation.
+func isDuplicateErr(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "Duplicate entry") || strings.Contains(err.Error(), "UNIQUE constraint failed"))
+}
+
+// ensureTables creates auxiliary tables using the DDL for the store's driver.
+// Every statement must be idempotent (CREATE ... IF NOT EXISTS).
+func (st *Store) ensureTables(ddl map[string][]string) error {
+	for _, stmt := range ddl[st.driver] {
+		if _, err := st.db.Exec(stmt); err != nil {
+			return fmt.Errorf("creating schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// tagID returns the id of the named tag, creating it if needed. Tags are looked
+// up by namespace and value; name keeps the combined form for existing consumers.
+func (st *Store) tagID(tagName string) (int, error) {
+	namespace, value := splitTag(tagName)
+	var tagID int
+	query := "SELECT id FROM tag WHERE namespace = ? AND value = ?"
+	err := st.db.QueryRow(query, namespace, value).Scan(&tagID)
+	if err == sql.ErrNoRows {
+		res, err := st.db.Exec("INSERT INTO tag (name, namespace, value) VALUES (?, ?, ?)", tagName, namespace, value)
+		if err != nil {
+			if !isDuplicateErr(err) {
+				return 0, fmt.Errorf("inserting tag '%s': %w", tagName, err)
+			}
+			err = st.db.QueryRow(query, namespace, value).Scan(&tagID)
+			if err != nil {
+				return 0, fmt.Errorf("querying tag '%s' after duplicate error: %w", tagName, err)
+			}
+		} else {
+			lastID, err := res.LastInsertId()
+			if err != nil {
+				return 0, fmt.Errorf("getting tag id for '%s': %w", tagName, err)
+			}
+			tagID = int(lastID)
+			st.metrics.tagCreated()
+		}
+	} else if err != nil {
+		return 0, fmt.Errorf("querying tag '%s': %w", tagName, err)
+	}
+	return tagID, nil
+}
+
+// setNames switches the connection to utf8mb4 on MySQL; SQLite is always UTF-8.
+func (st *Store) setNames() error {
+	if st.isSQLite() {
+		return nil
+	}
+	_, err := st.db.Exec("SET NAMES UTF8MB4")
+	return err
+}
+
+// walkGalleries calls fn with the gid and token of every gallery matching where
+// (columns of the gallery table, aliased g), newest first. Rows are read in small
+// chunks so fn may take long (e.g. fetch pages) without holding a cursor open.
+// Returning false from fn stops the walk.
+func (st *Store) walkGalleries(where string, fn func(gid int, token string) (bool, error)) error {
+	query := "SELECT g.gid, g.token FROM gallery g WHERE g.gid < ? AND (" + where + ") ORDER BY g.gid DESC LIMIT 100"
+	before := 1<<31 - 1
+	for {
+		rows, err := st.db.Query(query, before)
+		if err != nil {
+			return fmt.Errorf("querying galleries: %w", err)
+		}
+		type pending struct {
+			gid   int
+			token string
+		}
+		var chunk []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.gid, &p.token); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning gallery: %w", err)
+			}
+			chunk = append(chunk, p)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("reading galleries: %w", err)
+		}
+		if len(chunk) == 0 {
+			return nil
+		}
+		for _, p := range chunk {
+			more, err := fn(p.gid, p.token)
+			if err != nil {
+				return err
+			}
+			if !more {
+				return nil
+			}
+		}
+		before = chunk[len(chunk)-1].gid
+	}
+}
+
+type columnDef struct {
+	name       string
+	definition string
+}
+
+func (st *Store) hasColumn(table, column string) (bool, error) {
+	var n int
+	var err error
+	if st.isSQLite() {
+		err = st.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	} else {
+		err = st.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", table, column).Scan(&n)
+	}
+	if err != nil {
+		return false, fmt.Errorf("checking column %s.%s: %w", table, column, err)
+	}
+	return n > 0, nil
+}
+
+// hasColumns reports whether table has all of the given columns.
+func (st *Store) hasColumns(table string, columns []columnDef) (bool, error) {
+	for _, c := range columns {
+		ok, err := st.hasColumn(table, c.name)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (st *Store) hasTable(table string) (bool, error) {
+	var n int
+	var err error
+	if st.isSQLite() {
+		err = st.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+	} else {
+		err = st.db.QueryRow("SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", table).Scan(&n)
+	}
+	if err != nil {
+		return false, fmt.Errorf("checking table %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+// ensureIndex creates a (non-unique) index on table unless one with that name exists.
+func (st *Store) ensureIndex(table, name string, columns ...string) error {
+	cols := strings.Join(columns, ", ")
+	if st.isSQLite() {
+		if _, err := st.db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, cols)); err != nil {
+			return fmt.Errorf("creating index %s: %w", name, err)
+		}
+		return nil
+	}
+	var n int
+	err := st.db.QueryRow("SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?", table, name).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("checking index %s: %w", name, err)
+	}
+	if n > 0 {
+		return nil
+	}
+	infoLog("Adding index %s on %s", name, table)
+	if _, err := st.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD INDEX %s (%s)", table, name, cols)); err != nil {
+		return fmt.Errorf("creating index %s: %w", name, err)
+	}
+	return nil
+}
+
+// ensureColumns adds the columns missing from table, so dumps created before a
+// column was introduced keep working without a manual migration.
+func (st *Store) ensureColumns(table string, columns []columnDef) error {
+	for _, c := range columns {
+		ok, err := st.hasColumn(table, c.name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		infoLog("Adding column %s.%s", table, c.name)
+		if _, err := st.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, c.name, c.definition)); err != nil {
+			return fmt.Errorf("adding column %s.%s: %w", table, c.name, err)
+		}
+	}
+	return nil
+}
+
+// torrentSizeColumns are filled by saveTorrent from the parsed torrent sizes.
+var torrentSizeColumns = []columnDef{
+	{"fsize", "BIGINT DEFAULT NULL"},
+	{"fsizestr", "VARCHAR(15) DEFAULT NULL"},
+	{"fsize_min", "BIGINT DEFAULT NULL"},
+	{"fsize_max", "BIGINT DEFAULT NULL"},
+	{"tsize", "BIGINT DEFAULT NULL"},
+}
+
+// ensureSchema brings the auxiliary tables and columns the tool writes to up to date.
+func (st *Store) ensureSchema() error {
+	if err := st.ensureTables(changeLogDDL); err != nil {
+		return err
+	}
+	if err := st.ensureTagNamespaces(); err != nil {
+		return err
+	}
+	if err := st.ensureTables(tagAliasDDL); err != nil {
+		return err
+	}
+	if err := st.ensureColumns("torrent", torrentSizeColumns); err != nil {
+		return err
+	}
+	if err := st.ensureColumns("torrent", torrentStatColumns); err != nil {
+		return err
+	}
+	return st.detectSchema()
+}
+
+// detectSchema finds out which optional tables and columns exist without
+// changing anything, so read-only commands work with a read-only database
+// account. The tag namespace columns are required.
+func (st *Store) detectSchema() error {
+	ok, err := st.hasColumns("tag", tagNamespaceColumns)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("tag.namespace and tag.value are missing; run sync once to migrate the database")
+	}
+	if st.hasTorrentSizes, err = st.hasColumns("torrent", []columnDef{{name: "fsize"}, {name: "tsize"}}); err != nil {
+		return err
+	}
+	if st.hasTorrentStats, err = st.hasColumns("torrent", torrentStatColumns); err != nil {
+		return err
+	}
+	if st.hasGalleryDetail, err = st.hasColumns("gallery", galleryDetailColumns); err != nil {
+		return err
+	}
+	for _, t := range []struct {
+		name string
+		dst  *bool
+	}{{"tag_alias", &st.hasTagAliases}, {"tag_translation", &st.hasTranslations}, {"gallery_change", &st.hasChangeLog}, {"gallery_history", &st.hasHistory}} {
+		if *t.dst, err = st.hasTable(t.name); err != nil {
+			return err
+		}
+	}
+	return st.detectFullText()
+}
+
+// openReadOnlyStore opens the store for commands that only read it.
+func openReadOnlyStore() *Store {
+	st := openStore(loadConfig())
+	if err := st.detectSchema(); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	return st
+}
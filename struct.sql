@@ -16,10 +16,19 @@ CREATE TABLE IF NOT EXISTS `gallery` (
   `expunged` tinyint(1) NOT NULL,
   `removed` tinyint(1) NOT NULL DEFAULT 0,
   `replaced` tinyint(1) NOT NULL DEFAULT 0,
-  `rating` char(4) NOT NULL,
+  `rating` decimal(3,2) NOT NULL DEFAULT 0,
   `torrentcount` int(11) NOT NULL,
   `root_gid` int(11) DEFAULT NULL,
   `bytorrent` tinyint(1) NOT NULL DEFAULT 0,
+  `language` varchar(32) DEFAULT NULL,
+  `translated` tinyint(1) DEFAULT NULL,
+  `visible` tinyint(1) DEFAULT NULL,
+  `visible_reason` varchar(32) DEFAULT NULL,
+  `favorited` int(11) DEFAULT NULL,
+  `rating_count` int(11) DEFAULT NULL,
+  `page_width` int(11) DEFAULT NULL,
+  `page_height` int(11) DEFAULT NULL,
+  `detail_fetched` int(11) DEFAULT NULL,
   PRIMARY KEY (`gid`)
 ) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
 
@@ -31,7 +40,10 @@ CREATE TABLE IF NOT EXISTS `gid_tid` (
 CREATE TABLE IF NOT EXISTS `tag` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `name` varchar(200) NOT NULL,
-  PRIMARY KEY (`id`)
+  `namespace` varchar(20) DEFAULT NULL,
+  `value` varchar(200) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `tag_namespace_value` (`namespace`,`value`)
 ) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE `torrent` (
@@ -42,8 +54,68 @@ CREATE TABLE `torrent` (
   `addedstr` varchar(20) DEFAULT NULL,
   `added` datetime DEFAULT NULL,
   `fsizestr` varchar(15) DEFAULT NULL,
+  `fsize` BIGINT UNSIGNED DEFAULT NULL,
   `uploader` varchar(50) NOT NULL,
   `expunged` tinyint(1) NOT NULL DEFAULT 0,
   `fsize_min` BIGINT UNSIGNED,
-  `fsize_max` BIGINT UNSIGNED
-) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
+  `fsize_max` BIGINT UNSIGNED,
+  `tsize` BIGINT UNSIGNED DEFAULT NULL,
+  `seeders` int(11) DEFAULT NULL,
+  `leechers` int(11) DEFAULT NULL,
+  `downloads` int(11) DEFAULT NULL
+) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `gallery_change` (
+  `seq` bigint(20) NOT NULL AUTO_INCREMENT,
+  `gid` int(11) NOT NULL,
+  `changed_at` int(11) NOT NULL,
+  PRIMARY KEY (`seq`),
+  KEY `gid` (`gid`)
+) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `gallery_history` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `gid` int(11) NOT NULL,
+  `field` varchar(20) NOT NULL,
+  `old_value` varchar(512) DEFAULT NULL,
+  `new_value` varchar(512) DEFAULT NULL,
+  `changed_at` int(11) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `gid` (`gid`)
+) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `tag_translation` (
+  `tid` int(11) NOT NULL,
+  `lang` varchar(16) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `intro` text,
+  `links` text,
+  PRIMARY KEY (`tid`,`lang`)
+) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `tag_alias` (
+  `alias` varchar(200) NOT NULL,
+  `tid` int(11) NOT NULL,
+  PRIMARY KEY (`alias`),
+  KEY `tid` (`tid`)
+) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `sync_job` (
+  `name` varchar(32) NOT NULL,
+  `last_start` int(11) DEFAULT NULL,
+  `last_end` int(11) DEFAULT NULL,
+  `last_status` varchar(16) DEFAULT NULL,
+  `last_error` text,
+  `runs` int(11) NOT NULL DEFAULT 0,
+  `failures` int(11) NOT NULL DEFAULT 0,
+  `locked_by` varchar(100) DEFAULT NULL,
+  `locked_at` int(11) DEFAULT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `crawl_checkpoint` (
+  `profile` varchar(64) NOT NULL,
+  `last_gid` int(11) NOT NULL,
+  `updated_at` int(11) NOT NULL,
+  PRIMARY KEY (`profile`)
+) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4;
@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -20,32 +20,6 @@ import (
 	"github.com/spf13/viper"
 )
 
-var debugMode bool
-
-// --- Logging Helpers ---
-
-func debugLog(format string, a ...interface{}) {
-	if debugMode {
-		msg := fmt.Sprintf("[DEBUG] "+format, a...)
-		pterm.Debug.Println(msg)
-	}
-}
-
-func infoLog(format string, a ...interface{}) {
-	msg := fmt.Sprintf(format, a...)
-	pterm.Info.Println(msg)
-}
-
-func warnLog(format string, a ...interface{}) {
-	msg := fmt.Sprintf(format, a...)
-	pterm.Warning.Println(msg)
-}
-
-func errorLog(format string, a ...interface{}) {
-	msg := fmt.Sprintf(format, a...)
-	pterm.Error.Println(msg)
-}
-
 // --- Configuration using Viper with Environment Variables ---
 
 type Config struct {
@@ -56,14 +30,17 @@ type Config struct {
 	DBPass        string
 	DBName        string
 	SQLitePath    string
-	SleepDuration int // in seconds
-	RetryCount    int // number of retries for API and page fetch calls
+	SleepDuration int  // in seconds
+	RetryCount    int  // number of retries for API and page fetch calls
+	History       bool // record field-level gallery changes in gallery_history
 }
 
 func loadConfig() Config {
 	// Set default values
 	viper.SetDefault("sleep_duration", 10)
 	viper.SetDefault("retry_count", 3)
+	viper.SetDefault("log.format", logFormatAuto)
+	viper.SetDefault("log.level", "info")
 
 	// Bind environment variables (optionally with a prefix)
 	viper.AutomaticEnv()
@@ -78,13 +55,22 @@ func loadConfig() Config {
 	viper.BindEnv("database.sqlite_path", "DB_SQLITE_PATH")
 	// Bind sleep duration from environment variable SLEEP_DURATION
 	viper.BindEnv("sleep_duration", "SLEEP_DURATION")
+	viper.BindEnv("history", "HISTORY")
+	viper.BindEnv("log.format", "LOG_FORMAT")
+	viper.BindEnv("log.level", "LOG_LEVEL")
+	viper.BindEnv("log.file", "LOG_FILE")
 
 	// Read from config file if available
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
-	if err := viper.ReadInConfig(); err != nil {
-		warnLog("Error reading config file: %v. Falling back to environment variables.", err)
+	readErr := viper.ReadInConfig()
+	if err := setupLogging(); err != nil {
+		errorLog("Error setting up logging: %v", err)
+		os.Exit(1)
+	}
+	if readErr != nil {
+		warnLog("Error reading config file: %v. Falling back to environment variables.", readErr)
 	}
 
 	return Config{
@@ -97,6 +83,7 @@ func loadConfig() Config {
 		SQLitePath:    viper.GetString("database.sqlite_path"),
 		SleepDuration: viper.GetInt("sleep_duration"),
 		RetryCount:    viper.GetInt("retry_count"),
+		History:       viper.GetBool("history"),
 	}
 }
 
@@ -145,12 +132,19 @@ func loadExCookies(data []byte) (string, error) {
 // --- Data Structures for API and Page Entries ---
 
 type Options struct {
-	Site         string
-	Offset       int64 // number of hours to offset when fetching pages
-	CookieFile   string
-	OnlyExpunged bool
-	AlsoExpunged bool
-	Search       string
+	Site           string
+	Offset         int64 // number of hours to offset when fetching pages
+	CookieFile     string
+	OnlyExpunged   bool
+	AlsoExpunged   bool
+	Search         string
+	ScrapeTorrents bool
+	Enrich         bool     // fetch gallery pages for fields the API doesn't return
+	EnrichDims     bool     // with Enrich, also fetch first image pages for dimensions
+	Profiles       []string // crawl profiles from config.yaml, or "all"
+	From, To       string   // gid or YYYY-MM-DD bounds of the crawl
+	Backward       bool     // crawl from To down to From
+	Metrics        *Metrics
 }
 
 type PageEntry struct {
@@ -165,6 +159,13 @@ type TorrentInfo struct {
 	Name  string `json:"name"`
 	Tsize string `json:"tsize"`
 	Fsize string `json:"fsize"`
+	// Stored torrents also carry what scrape-torrents read from the torrent
+	// page; the API leaves these out.
+	ID        int    `json:"id,omitempty"`
+	Uploader  string `json:"uploader,omitempty"`
+	Seeders   *int   `json:"seeders,omitempty"`
+	Leechers  *int   `json:"leechers,omitempty"`
+	Downloads *int   `json:"downloads,omitempty"`
 }
 
 type GalleryMetadata struct {
@@ -176,48 +177,102 @@ type GalleryMetadata struct {
 	Category     string        `json:"category"`
 	Thumb        string        `json:"thumb"`
 	Uploader     string        `json:"uploader"`
-	Posted       string        `json:"posted"`
-	Filecount    string        `json:"filecount"`
+	Posted       int64         `json:"posted,string"`
+	Filecount    int           `json:"filecount,string"`
 	Filesize     int           `json:"filesize"`
 	Expunged     bool          `json:"expunged"`
-	Rating       string        `json:"rating"`
-	Torrentcount string        `json:"torrentcount"`
+	Rating       Rating        `json:"rating"`
+	Torrentcount int           `json:"torrentcount,string"`
 	Torrents     []TorrentInfo `json:"torrents"`
 	Tags         []string      `json:"tags"`
 	ParentGid    string        `json:"parent_gid"`
 	ParentKey    string        `json:"parent_key"`
 	FirstGid     string        `json:"first_gid"`
 	FirstKey     string        `json:"first_key"`
+	Error        string        `json:"error,omitempty"` // set by the API for unknown gids or bad tokens
+}
+
+// validate checks decoded values that the JSON types alone can't rule out.
+func (g GalleryMetadata) validate() error {
+	if g.Posted <= 0 {
+		return fmt.Errorf("gid %d: invalid posted %d", g.Gid, g.Posted)
+	}
+	if g.Filecount < 0 || g.Torrentcount < 0 || g.Filesize < 0 {
+		return fmt.Errorf("gid %d: negative filecount, filesize or torrentcount", g.Gid)
+	}
+	return nil
+}
+
+// Rating is a gallery rating between 0 and 5. The API encodes it as a string
+// such as "4.52", which is kept as the JSON form.
+type Rating float64
+
+func (r Rating) String() string {
+	return strconv.FormatFloat(float64(r), 'f', 2, 64)
+}
+
+func (r Rating) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Rating) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		// Tolerate a bare number as well.
+		str = string(data)
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return fmt.Errorf("invalid rating %s", data)
+	}
+	if v < 0 || v > 5 {
+		return fmt.Errorf("rating %s out of range", data)
+	}
+	*r = Rating(v)
+	return nil
 }
 
 type APIResponse struct {
-	Gmetadata []GalleryMetadata `json:"gmetadata"`
+	Gmetadata   []GalleryMetadata `json:"gmetadata"`
+	Unavailable []int             `json:"-"` // gids the API returned an error for
 }
 
 // --- Sync Structure ---
 
 type Sync struct {
-	host         string
-	offset       int64
-	cookies      string
-	db           *sql.DB
-	config       Config
-	client       *http.Client
-	onlyExpunged bool
-	alsoExpunged bool
-	search       string
+	host             string
+	offset           int64
+	cookies          string
+	config           Config
+	client           *http.Client
+	onlyExpunged     bool
+	alsoExpunged     bool
+	search           string
+	scrapeTorrents   bool
+	enrich           bool
+	enrichDimensions bool
+	tagAliases       map[string]string // alias name → canonical tag name
+	report           *RunReport
+	watches          []*WatchRule
+	notifier         *watchNotifier  // delivers watch events; nil without watch rules
+	profiles         []*CrawlProfile // crawled instead of the default passes
+	bounds           *crawlRange     // --from/--to, nil for an open crawl
+	*Store
 }
 
 // NewSync creates a new Sync instance based on provided options.
 // It now also reads cookie data from an environment variable if not provided via a file.
 func NewSync(opts Options) *Sync {
 	s := &Sync{
-		config:       loadConfig(),
-		client:       &http.Client{Timeout: 15 * time.Second},
-		offset:       opts.Offset,
-		onlyExpunged: opts.OnlyExpunged,
-		alsoExpunged: opts.AlsoExpunged,
-		search:       opts.Search, // assign the search from options
+		config:           loadConfig(),
+		client:           &http.Client{Timeout: 15 * time.Second},
+		offset:           opts.Offset,
+		onlyExpunged:     opts.OnlyExpunged,
+		alsoExpunged:     opts.AlsoExpunged,
+		search:           opts.Search, // assign the search from options
+		scrapeTorrents:   opts.ScrapeTorrents,
+		enrich:           opts.Enrich || opts.EnrichDims,
+		enrichDimensions: opts.EnrichDims,
 	}
 
 	if opts.Site == "exhentai" {
@@ -267,49 +322,56 @@ func NewSync(opts Options) *Sync {
 	}
 
 	s.initConnection()
-	return s
-}
-
-// initConnection establishes the database connection based on configuration.
-func (s *Sync) initConnection() {
-	driver := s.config.DBDriver
-	if driver == "" {
-		driver = "mysql"
-	}
-
-	var (
-		db  *sql.DB
-		err error
-	)
-
-	switch strings.ToLower(driver) {
-	case "sqlite", "sqlite3":
-		sqlitePath := s.config.SQLitePath
-		if sqlitePath == "" {
-			sqlitePath = s.config.DBName
-		}
-		if sqlitePath == "" {
-			errorLog("SQLite path must be provided via config or --sqlite-path when using sqlite driver")
-			os.Exit(1)
-		}
-		db, err = sql.Open("sqlite3", sqlitePath)
-	case "mysql":
-		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?timeout=10s",
-			s.config.DBUser, s.config.DBPass, s.config.DBHost, s.config.DBPort, s.config.DBName)
-		db, err = sql.Open("mysql", dsn)
-	default:
-		errorLog("Unsupported database driver: %s", driver)
+	s.metrics = opts.Metrics
+	if err := s.ensureSchema(); err != nil {
+		errorLog("Error preparing schema: %v", err)
 		os.Exit(1)
 	}
+	aliases, err := s.loadTagAliases()
 	if err != nil {
-		errorLog("Error opening DB: %v", err)
+		errorLog("Error loading tag aliases: %v", err)
 		os.Exit(1)
 	}
-	if err = db.Ping(); err != nil {
-		errorLog("Error pinging DB: %v", err)
+	s.tagAliases = aliases
+	if s.watches, err = loadWatchRules(); err != nil {
+		errorLog("Error: %v", err)
 		os.Exit(1)
 	}
-	s.db = db
+	if len(s.watches) > 0 {
+		s.notifier = startWatchNotifier()
+	}
+	if len(opts.Profiles) > 0 {
+		if s.profiles, err = loadCrawlProfiles(opts.Profiles); err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+	}
+	if opts.From != "" || opts.To != "" || opts.Backward {
+		if s.bounds, err = parseCrawlRange(opts.From, opts.To, opts.Backward); err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+	}
+	if s.enrich {
+		if err := s.ensureColumns("gallery", galleryDetailColumns); err != nil {
+			errorLog("Error preparing gallery detail columns: %v", err)
+			os.Exit(1)
+		}
+		s.hasGalleryDetail = true
+	}
+	if s.config.History {
+		if err := s.ensureTables(historyDDL); err != nil {
+			errorLog("Error preparing history table: %v", err)
+			os.Exit(1)
+		}
+		s.hasHistory = true
+	}
+	return s
+}
+
+// initConnection establishes the database connection based on configuration.
+func (s *Sync) initConnection() {
+	s.Store = openStore(s.config)
 }
 
 func (s *Sync) getLastGid() (int64, error) {
@@ -381,7 +443,7 @@ func (s *Sync) getOffsetExpungedGid(offset int64) (int64, error) {
 
 // runExpungedFetch performs the fetch loop exclusively for expunged galleries.
 func (s *Sync) runExpungedFetch() error {
-	_, err := s.db.Exec("SET NAMES UTF8MB4")
+	err := s.setNames()
 	if err != nil {
 		return err
 	}
@@ -401,87 +463,129 @@ func (s *Sync) runExpungedFetch() error {
 	}
 
 	infoLog("Starting expunged fetch with gid: %d", startGid)
-	prev := strconv.FormatInt(startGid, 10)
-	area, _ := pterm.DefaultArea.Start()
+	s.report.startPass("expunged", "", startGid)
+	return s.crawl(&CrawlProfile{Search: s.search, Expunged: true}, "prev="+strconv.FormatInt(startGid, 10), nil, nil)
+}
+
+// crawl walks the listing of p from cursor ("prev=<gid>", "next=<gid>",
+// "seek=<date>" or "" for the newest page) and imports every page. Without a
+// range it goes forward until there are no newer entries; with one it only
+// imports entries inside it and stops once a page reaches past its end. saved,
+// if set, is called with the newest gid of each imported page until a page
+// fails to import. It returns an error when a listing page could not be
+// fetched, which ends the crawl, or when a page could not be imported completely.
+func (s *Sync) crawl(p *CrawlProfile, cursor string, r *crawlRange, saved func(gid int64)) error {
+	label := ""
+	if p.Expunged {
+		label = "expunged "
+	}
+	fields := logFields{}
+	if p.Name != "" {
+		fields = logFields{"profile", p.Name}
+	}
+	progress := newPageProgress(p.Expunged)
+	// Once a page failed, the checkpoint stays before it so the next run
+	// imports it again.
+	pageFailed := false
+	failedPages := 0
+	var fetchErr error
 
 	for {
 		time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
 
 		var fetchURL string
 		var pageEntries []PageEntry
+		var err error
 		for attempt := 0; attempt < s.config.RetryCount; attempt++ {
-			fetchURL, pageEntries, err = s.getPagesByPrev(prev, true)
+			fetchURL, pageEntries, err = s.getListingPage(cursor, p)
 			if err == nil {
 				break
 			}
-			errorLog("Error fetching expunged page on attempt %d: %v", attempt+1, err)
+			append(fields, "url", fetchURL, "attempt", attempt+1).errorLog("Error fetching %spage on attempt %d: %v", label, attempt+1, err)
 			time.Sleep(1 * time.Second)
 		}
 		if err != nil {
-			errorLog("Error fetching expunged page after %d attempts: %v", s.config.RetryCount, err)
+			fields.errorLog("Error fetching %spage after %d attempts: %v", label, s.config.RetryCount, err)
+			s.report.pageFailed(fetchURL, err)
+			fetchErr = fmt.Errorf("fetching %spage %s: %w", label, fetchURL, err)
 			break
 		}
 		if len(pageEntries) == 0 {
-			infoLog("No new expunged entries found. Exiting expunged fetch loop.")
+			fields.infoLog("No new %sentries found. Exiting loop.", label)
 			break
 		}
 
-		pageCount := len(pageEntries)
-		apiCount, err := s.importPage(pageEntries)
-		if err != nil {
-			errorLog("Error importing expunged page: %v", err)
+		entries, done := pageEntries, false
+		if r != nil {
+			entries, done = r.filter(pageEntries)
 		}
-
-		newestEntryDate := "N/A"
-		if pageCount > 0 {
-			t, err := time.Parse("2006-01-02 15:04", pageEntries[0].Posted)
+		apiCount := 0
+		if len(entries) > 0 {
+			apiCount, _, err = s.importPage(entries)
 			if err != nil {
-				newestEntryDate = pageEntries[0].Posted
-			} else {
-				newestEntryDate = t.Format("2006-01-02")
+				fields.errorLog("Error importing %spage: %v", label, err)
+				if saved != nil && !pageFailed {
+					fields.warnLog("Not advancing the checkpoint for the rest of this run")
+				}
+				pageFailed = true
+				failedPages++
 			}
 		}
-		bulletItems := []pterm.BulletListItem{
-			{Level: 1, Text: fmt.Sprintf("Newest Expunged Entry Date: %s", newestEntryDate)},
-			{Level: 1, Text: fmt.Sprintf("Fetched Expunged Page Entries: %d", pageCount)},
-			{Level: 1, Text: fmt.Sprintf("Fetched Expunged API Entries: %d", apiCount)},
+		s.report.pageDone(entries)
+
+		newestEntryDate := "N/A"
+		if t, err := time.Parse("2006-01-02 15:04", pageEntries[0].Posted); err != nil {
+			newestEntryDate = pageEntries[0].Posted
+		} else {
+			newestEntryDate = t.Format("2006-01-02")
+		}
+		progress.update(fetchURL, newestEntryDate, len(entries), apiCount)
+		if done {
+			fields.infoLog("Reached the end of the %srange. Exiting loop.", label)
+			break
 		}
-		bulletStr, _ := pterm.DefaultBulletList.WithItems(bulletItems).Srender()
 
-		// Use the fetchURL returned from getPagesByPrev in the logging message.
-		fetchMsg := "Fetched page from " + fetchURL
-		prev = pageEntries[0].GID // Update prev based on the newest fetched entry.
-		area.Update(fetchMsg + "\n" + bulletStr)
+		if r != nil && r.backward {
+			cursor = "next=" + pageEntries[len(pageEntries)-1].GID // continue below the oldest entry
+			continue
+		}
+		cursor = "prev=" + pageEntries[0].GID // Update prev based on the newest fetched entry.
+		if gid, err := strconv.ParseInt(pageEntries[0].GID, 10, 64); err == nil && saved != nil && !pageFailed {
+			saved(gid)
+		}
+	}
+	progress.stop()
+	if fetchErr != nil {
+		return fetchErr
+	}
+	if failedPages > 0 {
+		return fmt.Errorf("%d %spages could not be imported completely", failedPages, label)
 	}
 	return nil
 }
 
 // --- Page Fetching Helpers ---
 
-func (s *Sync) getPagesByPrev(prev string, expunged bool) (string, []PageEntry, error) {
-	// Set the extra search parameter if a search is provided.
-	searchParam := ""
-	if s.search != "" {
-		searchParam = "&f_search=" + url.QueryEscape(s.search)
-	}
+// getListingPage fetches and parses the listing page of p at cursor.
+func (s *Sync) getListingPage(cursor string, p *CrawlProfile) (string, []PageEntry, error) {
+	fetchURL := "https://" + s.host + p.listingPath(cursor)
 
-	// Set the flag for expunged if needed.
-	var f_sh string
-	if expunged {
-		f_sh = "&f_sh=on"
-	} else {
-		f_sh = ""
+	bodyStr, err := s.fetchPage(fetchURL)
+	if err != nil {
+		return fetchURL, nil, err
 	}
 
-	// Build the path and URL.
-	path := fmt.Sprintf("/?prev=%s&f_cats=0&advsearch=1&f_sname=on&f_ssearchs=on%s%s&f_spf=&f_spt=&f_sft=on&f_sfu=on&f_sfl=on",
-		prev, f_sh, searchParam)
-	fetchURL := "https://" + s.host + path
+	// Parse the page entries.
+	entries, err := parsePageEntries(bodyStr)
+	return fetchURL, entries, err
+}
 
-	// Create and set up the HTTP request.
+// fetchPage downloads a site page with browser-like headers and the configured
+// cookies, retrying failed requests and waiting out ban cooldowns.
+func (s *Sync) fetchPage(fetchURL string) (string, error) {
 	req, err := http.NewRequest("GET", fetchURL, nil)
 	if err != nil {
-		return fetchURL, nil, err
+		return "", err
 	}
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*")
 	req.Header.Set("Accept-Language", "en-US;q=0.9,en;q=0.8")
@@ -496,44 +600,48 @@ func (s *Sync) getPagesByPrev(prev string, expunged bool) (string, []PageEntry,
 	var bodyStr string
 	var fetchErr error
 	for attempt := 0; attempt < s.config.RetryCount; attempt++ {
+		fetchErr = nil
 		resp, err := s.client.Do(req)
 		if err != nil {
 			fetchErr = err
-			errorLog("Error fetching page on attempt %d: %v", attempt+1, err)
+			logFields{"url", fetchURL, "attempt", attempt + 1}.errorLog("Error fetching page on attempt %d: %v", attempt+1, err)
+			s.metrics.pageRetried()
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		if resp.StatusCode != 200 {
 			fetchErr = fmt.Errorf("HTTP status code: %d", resp.StatusCode)
-			errorLog("Error fetching page on attempt %d: %v", attempt+1, fetchErr)
+			logFields{"url", fetchURL, "attempt", attempt + 1}.errorLog("Error fetching page on attempt %d: %v", attempt+1, fetchErr)
+			s.metrics.pageRetried()
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			fetchErr = err
-			errorLog("Error reading response on attempt %d: %v", attempt+1, err)
+			logFields{"url", fetchURL, "attempt", attempt + 1}.errorLog("Error reading response on attempt %d: %v", attempt+1, err)
+			s.metrics.pageRetried()
 			time.Sleep(1 * time.Second)
 			continue
 		}
 		bodyStr = string(body)
+		s.metrics.pageFetched()
 		break
 	}
 	if fetchErr != nil {
-		return fetchURL, nil, fetchErr
+		return "", fetchErr
 	}
 
 	// Check for ban cooldown in the response.
 	if banned, waitTime := extractBanCooldown(bodyStr); banned {
-		infoLog("Detected ban message. Initiating cooldown for %d seconds.", waitTime)
+		logFields{"url", fetchURL, "wait_seconds", waitTime}.infoLog("Detected ban message. Initiating cooldown for %d seconds.", waitTime)
+		s.metrics.banned(waitTime)
+		s.report.banned(waitTime)
 		runBanCooldown(waitTime)
-		return s.getPagesByPrev(prev, expunged)
+		return s.fetchPage(fetchURL)
 	}
-
-	// Parse the page entries.
-	entries, err := parsePageEntries(bodyStr)
-	return fetchURL, entries, err
+	return bodyStr, nil
 }
 
 func extractBanCooldown(body string) (bool, int) {
@@ -575,6 +683,10 @@ func extractBanCooldown(body string) (bool, int) {
 }
 
 func runBanCooldown(totalWait int) {
+	if !progressEnabled {
+		time.Sleep(time.Duration(totalWait) * time.Second)
+		return
+	}
 	pb, _ := pterm.DefaultProgressbar.
 		WithTotal(totalWait).
 		WithTitle("Ban Cooldown").
@@ -625,67 +737,108 @@ func (s *Sync) getMetadatas(gidlist []PageEntry) (*APIResponse, error) {
 		"gidlist":   payloadGidlist,
 		"namespace": 1,
 	}
-	jsonData, err := json.Marshal(payload)
+	var apiResp *APIResponse
+	err := s.callAPI(payload, func(body []byte) error {
+		result, err := decodeAPIResponse(body)
+		apiResp = result
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	url := "https://api.e-hentai.org/api.php"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	return apiResp, nil
+}
+
+// callAPI posts payload to the API and passes the response body to decode,
+// retrying failed requests and responses decode rejects.
+func (s *Sync) callAPI(payload interface{}, decode func(body []byte) error) error {
+	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	req.Header.Set("Accept", "application/json;q=0.9,*/*")
-	req.Header.Set("Accept-Language", "en-US;q=0.9,en;q=0.8")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.142 Safari/537.36")
-
-	var apiResp *APIResponse
+	url := "https://api.e-hentai.org/api.php"
 	for attempt := 0; attempt < s.config.RetryCount; attempt++ {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(jsonData))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json;q=0.9,*/*")
+		req.Header.Set("Accept-Language", "en-US;q=0.9,en;q=0.8")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("DNT", "1")
+		req.Header.Set("Upgrade-Insecure-Requests", "1")
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.142 Safari/537.36")
+
 		resp, err := s.client.Do(req)
 		if err != nil {
-			errorLog("Error calling API on attempt %d: %v", attempt+1, err)
+			logFields{"attempt", attempt + 1}.errorLog("Error calling API on attempt %d: %v", attempt+1, err)
+			s.metrics.apiRetried()
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		if resp.StatusCode != 200 {
 			err = fmt.Errorf("HTTP status code: %d", resp.StatusCode)
-			errorLog("Error calling API on attempt %d: %v", attempt+1, err)
+			logFields{"attempt", attempt + 1}.errorLog("Error calling API on attempt %d: %v", attempt+1, err)
+			s.metrics.apiRetried()
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			errorLog("Error reading API response on attempt %d: %v", attempt+1, err)
+			logFields{"attempt", attempt + 1}.errorLog("Error reading API response on attempt %d: %v", attempt+1, err)
+			s.metrics.apiRetried()
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		var result APIResponse
-		if err = json.Unmarshal(body, &result); err != nil {
-			errorLog("Error unmarshalling API response on attempt %d: %v", attempt+1, err)
+		if err := decode(body); err != nil {
+			logFields{"attempt", attempt + 1}.errorLog("Error unmarshalling API response on attempt %d: %v", attempt+1, err)
+			s.metrics.apiRetried()
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		apiResp = &result
-		break
+		return nil
 	}
-	if apiResp == nil {
-		return nil, fmt.Errorf("failed to get valid API response after %d attempts", s.config.RetryCount)
+	return fmt.Errorf("failed to get valid API response after %d attempts", s.config.RetryCount)
+}
+
+// decodeAPIResponse decodes a gdata response entry by entry, so a malformed gallery
+// or an API error entry is reported and skipped instead of failing the whole batch.
+func decodeAPIResponse(body []byte) (*APIResponse, error) {
+	var raw struct {
+		Gmetadata []json.RawMessage `json:"gmetadata"`
 	}
-	return apiResp, nil
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	result := &APIResponse{Gmetadata: make([]GalleryMetadata, 0, len(raw.Gmetadata))}
+	for _, entry := range raw.Gmetadata {
+		var gallery GalleryMetadata
+		if err := json.Unmarshal(entry, &gallery); err != nil {
+			var id struct {
+				Gid int `json:"gid"`
+			}
+			json.Unmarshal(entry, &id)
+			logFields{"gid", id.Gid}.errorLog("Skipping malformed metadata for gid %d: %v", id.Gid, err)
+			continue
+		}
+		if gallery.Error != "" {
+			logFields{"gid", gallery.Gid}.errorLog("API returned an error for gid %d: %s", gallery.Gid, gallery.Error)
+			result.Unavailable = append(result.Unavailable, gallery.Gid)
+			continue
+		}
+		if err := gallery.validate(); err != nil {
+			errorLog("Skipping invalid metadata: %v", err)
+			continue
+		}
+		result.Gmetadata = append(result.Gmetadata, gallery)
+	}
+	return result, nil
 }
 
 // --- Database Insert Helpers ---
 
 func (s *Sync) saveGallery(gallery GalleryMetadata) error {
-	postedInt, err := strconv.ParseInt(gallery.Posted, 10, 64)
-	if err != nil {
-		return fmt.Errorf("parsing posted time for gid %d: %w", gallery.Gid, err)
-	}
-	filecountInt, _ := strconv.Atoi(gallery.Filecount)
-	torrentcountInt, _ := strconv.Atoi(gallery.Torrentcount)
 	rootGidInt := 0
 	if gallery.ParentGid != "" {
 		rootGidInt, _ = strconv.Atoi(gallery.ParentGid)
@@ -694,33 +847,60 @@ func (s *Sync) saveGallery(gallery GalleryMetadata) error {
 	if gallery.Expunged {
 		expungedInt = 1
 	}
-	stmt, err := s.db.Prepare(`
-      INSERT INTO gallery (gid, token, archiver_key, title, title_jpn, category, thumb, uploader, posted, filecount, filesize, expunged, rating, torrentcount, root_gid, bytorrent)
-      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-      ON DUPLICATE KEY UPDATE token=VALUES(token), archiver_key=VALUES(archiver_key), title=VALUES(title), title_jpn=VALUES(title_jpn), category=VALUES(category), thumb=VALUES(thumb), uploader=VALUES(uploader), posted=VALUES(posted), filecount=VALUES(filecount), filesize=VALUES(filesize), expunged=VALUES(expunged), rating=VALUES(rating), torrentcount=VALUES(torrentcount), root_gid=VALUES(root_gid)
-    `)
+	stmt, err := s.db.Prepare(s.upsertSQL("gallery", "gid",
+		[]string{"gid", "token", "archiver_key", "title", "title_jpn", "category", "thumb", "uploader", "posted", "filecount", "filesize", "expunged", "rating", "torrentcount", "root_gid", "bytorrent"},
+		[]string{"token", "archiver_key", "title", "title_jpn", "category", "thumb", "uploader", "posted", "filecount", "filesize", "expunged", "rating", "torrentcount", "root_gid"}))
 	if err != nil {
 		return fmt.Errorf("preparing gallery stmt: %w", err)
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(gallery.Gid, gallery.Token, gallery.ArchiverKey, gallery.Title, gallery.TitleJpn, gallery.Category, gallery.Thumb, gallery.Uploader, postedInt, filecountInt, gallery.Filesize, expungedInt, gallery.Rating, torrentcountInt, rootGidInt, 0)
+	_, err = stmt.Exec(gallery.Gid, gallery.Token, gallery.ArchiverKey, gallery.Title, gallery.TitleJpn, gallery.Category, gallery.Thumb, gallery.Uploader, gallery.Posted, gallery.Filecount, gallery.Filesize, expungedInt, gallery.Rating.String(), gallery.Torrentcount, rootGidInt, 0)
 	if err != nil {
 		return fmt.Errorf("inserting gallery gid %d: %w", gallery.Gid, err)
 	}
-	debugLog("Inserted gallery gid %d", gallery.Gid)
+	logFields{"gid", gallery.Gid}.debugLog("Inserted gallery gid %d", gallery.Gid)
 	return nil
 }
 
-func (s *Sync) saveTorrent(gid int, torrent TorrentInfo, uploader string) error {
-	stmt, err := s.db.Prepare(`
-          INSERT INTO torrent (gid, name, hash, added, fsize, uploader, expunged)
-          VALUES (?, ?, ?, FROM_UNIXTIME(?), ?, ?, ?)
+// saveTorrent inserts a torrent row. Sizes are parsed in Go so that fsize,
+// fsize_min and fsize_max are filled for API byte counts as well as for human
+// readable sizes like "1.23 GiB" (which are also kept in fsizestr). An empty
+// Added is stored as NULL.
+func (st *Store) saveTorrent(gid int, torrent TorrentInfo, uploader string) error {
+	var fsize, fsizeMin, fsizeMax, tsize sql.NullInt64
+	var fsizeStr sql.NullString
+	added := sql.NullString{String: torrent.Added, Valid: torrent.Added != ""}
+	if torrent.Fsize != "" {
+		size, err := parseByteSize(torrent.Fsize)
+		if err != nil {
+			warnLog("Torrent %s for gid %d: %v", torrent.Hash, gid, err)
+		} else {
+			fsize = sql.NullInt64{Int64: size.Bytes, Valid: true}
+			fsizeMin = sql.NullInt64{Int64: size.Min, Valid: true}
+			fsizeMax = sql.NullInt64{Int64: size.Max, Valid: true}
+			if !size.Exact {
+				fsizeStr = sql.NullString{String: torrent.Fsize, Valid: true}
+			}
+		}
+	}
+	if torrent.Tsize != "" {
+		size, err := parseByteSize(torrent.Tsize)
+		if err != nil {
+			warnLog("Torrent %s for gid %d: %v", torrent.Hash, gid, err)
+		} else {
+			tsize = sql.NullInt64{Int64: size.Bytes, Valid: true}
+		}
+	}
+
+	stmt, err := st.db.Prepare(`
+          INSERT INTO torrent (gid, name, hash, added, fsize, fsizestr, fsize_min, fsize_max, tsize, uploader, expunged)
+          VALUES (?, ?, ?, ` + st.fromUnixTimeExpr() + `, ?, ?, ?, ?, ?, ?, ?)
     `)
 	if err != nil {
 		return fmt.Errorf("preparing torrent stmt: %w", err)
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(gid, torrent.Name, torrent.Hash, torrent.Added, torrent.Fsize, uploader, 0)
+	_, err = stmt.Exec(gid, torrent.Name, torrent.Hash, added, fsize, fsizeStr, fsizeMin, fsizeMax, tsize, uploader, 0)
 	if err != nil {
 		return fmt.Errorf("inserting torrent for gid %d: %w", gid, err)
 	}
@@ -729,30 +909,12 @@ func (s *Sync) saveTorrent(gid int, torrent TorrentInfo, uploader string) error
 }
 
 func (s *Sync) saveTag(gid int, tagName string) error {
-	var tagID int
-	err := s.db.QueryRow("SELECT id FROM tag WHERE name = ?", tagName).Scan(&tagID)
-	if err == sql.ErrNoRows {
-		res, err := s.db.Exec("INSERT INTO tag (name) VALUES (?)", tagName)
-		if err != nil {
-			if !strings.Contains(err.Error(), "Duplicate entry") {
-				return fmt.Errorf("inserting tag '%s': %w", tagName, err)
-			}
-			err = s.db.QueryRow("SELECT id FROM tag WHERE name = ?", tagName).Scan(&tagID)
-			if err != nil {
-				return fmt.Errorf("querying tag '%s' after duplicate error: %w", tagName, err)
-			}
-		} else {
-			lastID, err := res.LastInsertId()
-			if err != nil {
-				return fmt.Errorf("getting tag id for '%s': %w", tagName, err)
-			}
-			tagID = int(lastID)
-		}
-	} else if err != nil {
-		return fmt.Errorf("querying tag '%s': %w", tagName, err)
+	tagID, err := s.tagID(tagName)
+	if err != nil {
+		return err
 	}
 	_, err = s.db.Exec("INSERT INTO gid_tid (gid, tid) VALUES (?, ?)", gid, tagID)
-	if err != nil && !strings.Contains(err.Error(), "Duplicate entry") {
+	if err != nil && !isDuplicateErr(err) {
 		return fmt.Errorf("inserting gid_tid for gid %d and tag '%s': %w", gid, tagName, err)
 	}
 	debugLog("Linked gallery gid %d with tag '%s'", gid, tagName)
@@ -761,8 +923,23 @@ func (s *Sync) saveTag(gid int, tagName string) error {
 
 // --- Page Import and Processing ---
 
-func (s *Sync) importPage(entries []PageEntry) (int, error) {
+// importError lists the galleries importPage could not import.
+type importError struct {
+	Gids  []int
+	Total int
+}
+
+func (e *importError) Error() string {
+	return fmt.Sprintf("%d of %d galleries could not be imported", len(e.Gids), e.Total)
+}
+
+// importPage fetches the metadata of entries in batches and saves it. It
+// returns the number of galleries the API returned, the gids it reported as
+// unavailable, and an *importError when a batch or gallery could not be
+// imported; the other batches are imported anyway.
+func (s *Sync) importPage(entries []PageEntry) (int, []int, error) {
 	pageAPIEntries := 0
+	var failed, unavailable []int
 	const batchSize = 25
 
 	for i := 0; i < len(entries); i += batchSize {
@@ -771,6 +948,9 @@ func (s *Sync) importPage(entries []PageEntry) (int, error) {
 			end = len(entries)
 		}
 		batch := entries[i:end]
+		if i > 0 {
+			time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
+		}
 
 		var apiResp *APIResponse
 		var err error
@@ -779,39 +959,145 @@ func (s *Sync) importPage(entries []PageEntry) (int, error) {
 			if err == nil {
 				break
 			}
-			errorLog("Error calling API for batch %d on attempt %d: %v", i/batchSize, attempt+1, err)
+			logFields{"batch", i / batchSize, "attempt", attempt + 1}.errorLog("Error calling API for batch %d on attempt %d: %v", i/batchSize, attempt+1, err)
 			time.Sleep(1 * time.Second)
 		}
 		if err != nil {
-			errorLog("Error calling API for batch %d after %d attempts: %v", i/batchSize, s.config.RetryCount, err)
+			logFields{"batch", i / batchSize}.errorLog("Error calling API for batch %d after %d attempts: %v", i/batchSize, s.config.RetryCount, err)
+			s.metrics.apiBatch(false)
+			s.report.batchFailed(batch, err)
+			for _, e := range batch {
+				if gid, err := strconv.Atoi(e.GID); err == nil {
+					failed = append(failed, gid)
+				}
+			}
 			continue
 		}
+		s.metrics.apiBatch(true)
+		s.report.galleriesRemoved(apiResp.Unavailable)
+		unavailable = append(unavailable, apiResp.Unavailable...)
 
 		if len(apiResp.Gmetadata) == 0 {
-			errorLog("API response returned no entries for batch %d", i/batchSize)
+			logFields{"batch", i / batchSize}.errorLog("API response returned no entries for batch %d", i/batchSize)
 			continue
 		}
 
 		pageAPIEntries += len(apiResp.Gmetadata)
 
-		for _, gallery := range apiResp.Gmetadata {
-			if err := s.saveGallery(gallery); err != nil {
-				errorLog("Error saving gallery: %v", err)
+		inserted, unsaved := s.saveGalleries(apiResp.Gmetadata)
+		failed = append(failed, unsaved...)
+		s.notifyWatches(inserted)
+		if s.scrapeTorrents {
+			s.scrapeTorrentsFor(apiResp.Gmetadata)
+		}
+		if s.enrich {
+			s.enrichGalleries(apiResp.Gmetadata)
+		}
+	}
+	if len(failed) > 0 {
+		return pageAPIEntries, unavailable, &importError{Gids: failed, Total: len(entries)}
+	}
+	return pageAPIEntries, unavailable, nil
+}
+
+// saveGalleries stores a batch of API results. Each gallery is compared with its
+// stored state first so only real changes reach the change log and tags and
+// torrents that are already linked are not inserted again. Aliased tags are
+// stored under their canonical name, and tags the API no longer returns are unlinked.
+// It returns the galleries that were not stored before, with canonical tags,
+// and the gids of galleries that could not be saved.
+func (s *Sync) saveGalleries(galleries []GalleryMetadata) ([]GalleryMetadata, []int) {
+	gids := make([]int, len(galleries))
+	for i, gallery := range galleries {
+		gids[i] = gallery.Gid
+	}
+	stored, err := s.loadGalleryRecords(gids)
+	if err != nil {
+		// Without the stored state every gallery would count as new and fire
+		// its watch rules again, so the batch is left for the next run.
+		errorLog("Error loading stored galleries: %v", err)
+		return nil, gids
+	}
+
+	var inserted []GalleryMetadata
+	var unsaved []int
+	for _, gallery := range galleries {
+		old := stored[gallery.Gid]
+		knownTags := make(map[string]bool)
+		knownTorrents := make(map[string]bool)
+		changed := old == nil
+		var changes []fieldChange
+		if old != nil {
+			for _, name := range old.Tags {
+				knownTags[name] = true
+			}
+			for _, t := range old.Torrents {
+				knownTorrents[t.Hash] = true
+			}
+			changes = galleryFieldChanges(old, gallery)
+			changed = len(changes) > 0
+		}
+
+		if err := s.saveGallery(gallery); err != nil {
+			logFields{"gid", gallery.Gid}.errorLog("Error saving gallery: %v", err)
+			unsaved = append(unsaved, gallery.Gid)
+			continue
+		}
+		if old != nil && old.Torrentcount != gallery.Torrentcount {
+			// New or removed torrents: scrape-torrents has to check the page again.
+			if _, err := s.db.Exec("UPDATE gallery SET bytorrent = 0 WHERE gid = ?", gallery.Gid); err != nil {
+				logFields{"gid", gallery.Gid}.errorLog("Error resetting bytorrent for gid %d: %v", gallery.Gid, err)
+			}
+		}
+		for _, t := range gallery.Torrents {
+			if knownTorrents[t.Hash] {
 				continue
 			}
-			for _, t := range gallery.Torrents {
-				if err := s.saveTorrent(gallery.Gid, t, gallery.Uploader); err != nil {
-					errorLog("Error saving torrent for gid %d: %v", gallery.Gid, err)
-				}
+			changed = true
+			if err := s.saveTorrent(gallery.Gid, t, gallery.Uploader); err != nil {
+				logFields{"gid", gallery.Gid}.errorLog("Error saving torrent for gid %d: %v", gallery.Gid, err)
 			}
-			for _, tagName := range gallery.Tags {
-				if err := s.saveTag(gallery.Gid, tagName); err != nil {
-					errorLog("Error saving tag for gid %d: %v", gallery.Gid, err)
-				}
+		}
+		tags := canonicalTags(gallery.Tags, s.tagAliases)
+		if old == nil {
+			g := gallery
+			g.Tags = tags
+			inserted = append(inserted, g)
+		}
+		current := make(map[string]bool, len(tags))
+		for _, tagName := range tags {
+			current[tagName] = true
+			if knownTags[tagName] {
+				continue
+			}
+			changed = true
+			if err := s.saveTag(gallery.Gid, tagName); err != nil {
+				logFields{"gid", gallery.Gid}.errorLog("Error saving tag for gid %d: %v", gallery.Gid, err)
+			}
+		}
+		for tagName := range knownTags {
+			if current[tagName] {
+				continue
+			}
+			changed = true
+			if err := s.unlinkTag(gallery.Gid, tagName); err != nil {
+				logFields{"gid", gallery.Gid}.errorLog("Error unlinking tag for gid %d: %v", gallery.Gid, err)
+			}
+		}
+		if changed {
+			s.metrics.gallerySaved(old == nil, gallery.Posted)
+			s.report.gallerySaved(old == nil, gallery.Expunged && (old == nil || !old.Expunged))
+			if err := s.recordChange(gallery.Gid); err != nil {
+				logFields{"gid", gallery.Gid}.errorLog("Error recording change for gid %d: %v", gallery.Gid, err)
+			}
+		}
+		if s.config.History && len(changes) > 0 {
+			if err := s.recordHistory(gallery.Gid, changes); err != nil {
+				logFields{"gid", gallery.Gid}.errorLog("Error recording history for gid %d: %v", gallery.Gid, err)
 			}
 		}
 	}
-	return pageAPIEntries, nil
+	return inserted, unsaved
 }
 
 // --- Reporting ---
@@ -820,32 +1106,34 @@ func (s *Sync) importPage(entries []PageEntry) (int, error) {
 // It prints the total number of entries in the gallery table,
 // the last posted gallery ID, and the cutoff time formatted as "YYYY-MM-DD HH:MM UTC+0".
 func (s *Sync) generateReport() error {
-	var totalEntries int
-	if err := s.db.QueryRow("SELECT COUNT(*) FROM gallery").Scan(&totalEntries); err != nil {
-		return fmt.Errorf("error retrieving total entry count: %w", err)
-	}
-
-	var lastGid int64
-	var lastPosted int64
-	if err := s.db.QueryRow("SELECT gid, posted FROM gallery ORDER BY posted DESC LIMIT 1").Scan(&lastGid, &lastPosted); err != nil {
-		return fmt.Errorf("error retrieving last posted gallery: %w", err)
+	sum, err := s.databaseSummary()
+	if err != nil {
+		return err
 	}
 
 	// Convert the posted timestamp (assumed to be Unix seconds) to a formatted UTC string.
-	cutoffTime := time.Unix(lastPosted, 0).UTC().Format("2006-01-02 15:04") + " UTC+0"
-	report := fmt.Sprintf("\nFINAL REPORT:\nTotal entries in database: %d\nLast posted ID: %d\nCutoff time: %s\n", totalEntries, lastGid, cutoffTime)
-	infoLog(report)
+	cutoffTime := time.Unix(sum.LastPosted, 0).UTC().Format("2006-01-02 15:04") + " UTC+0"
+	report := fmt.Sprintf("\nFINAL REPORT:\nTotal entries in database: %d\nLast posted ID: %d\nCutoff time: %s\n", sum.Galleries, sum.LastGid, cutoffTime)
+	infoLog("%s", report)
 	return nil
 }
 
 // run retrieves the starting gallery entry then loops fetching pages using a sleep duration.
 // It now uses the offset option if provided.
 func (s *Sync) run() error {
-	_, err := s.db.Exec("SET NAMES UTF8MB4")
+	err := s.setNames()
 	if err != nil {
 		return err
 	}
 
+	// Profiles replace the default passes.
+	if len(s.profiles) > 0 {
+		return s.runProfiles()
+	}
+	if s.bounds != nil {
+		return s.runBounded()
+	}
+
 	// If only expunged mode is enabled, run the expunged fetch loop exclusively.
 	if s.onlyExpunged {
 		infoLog("Running in only-expunged mode.")
@@ -867,131 +1155,229 @@ func (s *Sync) run() error {
 		}
 		infoLog("Got last gid = %d", startGid)
 	}
-	prev := strconv.FormatInt(startGid, 10)
-	area, _ := pterm.DefaultArea.Start()
-
-	for {
-		time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
-		var fetchURL string
-		var pageEntries []PageEntry
-
-		// Try to fetch the page and retrieve the URL used.
-		for attempt := 0; attempt < s.config.RetryCount; attempt++ {
-			fetchURL, pageEntries, err = s.getPagesByPrev(prev, false)
-			if err == nil {
-				break
-			}
-			errorLog("Error fetching page on attempt %d: %v", attempt+1, err)
-			time.Sleep(1 * time.Second)
-		}
-		if err != nil {
-			errorLog("Error fetching page after %d attempts: %v", s.config.RetryCount, err)
-			break
-		}
-		if len(pageEntries) == 0 {
-			infoLog("No new entries found. Exiting loop.")
-			break
-		}
-
-		pageCount := len(pageEntries)
-		apiCount, err := s.importPage(pageEntries)
-		if err != nil {
-			errorLog("Error importing page: %v", err)
-		}
-
-		newestEntryDate := "N/A"
-		if pageCount > 0 {
-			t, err := time.Parse("2006-01-02 15:04", pageEntries[0].Posted)
-			if err != nil {
-				newestEntryDate = pageEntries[0].Posted
-			} else {
-				newestEntryDate = t.Format("2006-01-02")
-			}
-		}
-
-		bulletItems := []pterm.BulletListItem{
-			{Level: 1, Text: fmt.Sprintf("Newest Entry Date: %s", newestEntryDate)},
-			{Level: 1, Text: fmt.Sprintf("Fetched Page Entries: %d", pageCount)},
-			{Level: 1, Text: fmt.Sprintf("Fetched API Entries: %d", apiCount)},
-		}
-		bulletStr, _ := pterm.DefaultBulletList.WithItems(bulletItems).Srender()
-
-		// Use the fetchURL returned from getPagesByPrev in the logging message.
-		fetchMsg := "Fetched page from " + fetchURL
-		prev = pageEntries[0].GID // Update prev based on the newest fetched entry.
-		area.Update(fetchMsg + "\n" + bulletStr)
-	}
+	s.report.startPass("normal", "", startGid)
+	err = s.crawl(&CrawlProfile{Search: s.search}, "prev="+strconv.FormatInt(startGid, 10), nil, nil)
 
 	// (Optionally, continue with expunged fetching if also-expunged option is enabled.)
 	if s.alsoExpunged {
 		infoLog("Normal fetch completed. Now starting expunged fetch as per also-expunged option.")
-		if err := s.runExpungedFetch(); err != nil {
-			errorLog("Error during expunged fetch: %v", err)
+		if expErr := s.runExpungedFetch(); expErr != nil {
+			errorLog("Error during expunged fetch: %v", expErr)
+			err = errors.Join(err, expErr)
 		}
 	}
-	return nil
+	return err
 }
 
 // --- Main ---
 
+// dbFlags holds the database connection flags shared by every command.
+type dbFlags struct {
+	driver     *string
+	host       *string
+	port       *string
+	user       *string
+	pass       *string
+	name       *string
+	sqlitePath *string
+	debug      *bool
+	logFormat  *string
+	logLevel   *string
+	logFile    *string
+}
+
+func registerDBFlags(fs *flag.FlagSet) *dbFlags {
+	return &dbFlags{
+		driver:     fs.String("db-driver", "", "Database driver (mysql or sqlite)"),
+		host:       fs.String("db-host", "", "Database host"),
+		port:       fs.String("db-port", "", "Database port"),
+		user:       fs.String("db-user", "", "Database user"),
+		pass:       fs.String("db-pass", "", "Database password"),
+		name:       fs.String("db-name", "", "Database name (or SQLite filename)"),
+		sqlitePath: fs.String("sqlite-path", "", "SQLite database file path"),
+		debug:      fs.Bool("debug", false, "Enable debug logging"),
+		logFormat:  fs.String("log-format", "", "Log format: auto, pretty, text or json"),
+		logLevel:   fs.String("log-level", "", "Log level: debug, info, warn or error"),
+		logFile:    fs.String("log-file", "", "Also append logs to this file"),
+	}
+}
+
+// apply overrides viper config with command line arguments if provided.
+func (f *dbFlags) apply() {
+	debugMode = *f.debug
+	if *f.driver != "" {
+		viper.Set("database.driver", *f.driver)
+	}
+	if *f.host != "" {
+		viper.Set("database.host", *f.host)
+	}
+	if *f.port != "" {
+		viper.Set("database.port", *f.port)
+	}
+	if *f.user != "" {
+		viper.Set("database.user", *f.user)
+	}
+	if *f.pass != "" {
+		viper.Set("database.password", *f.pass)
+	}
+	if *f.name != "" {
+		viper.Set("database.name", *f.name)
+	}
+	if *f.sqlitePath != "" {
+		viper.Set("database.sqlite_path", *f.sqlitePath)
+	}
+	if *f.logFormat != "" {
+		viper.Set("log.format", *f.logFormat)
+	}
+	if *f.logLevel != "" {
+		viper.Set("log.level", *f.logLevel)
+	}
+	if *f.logFile != "" {
+		viper.Set("log.file", *f.logFile)
+	}
+}
+
 func main() {
+	// Subcommands; without one the tool runs the sync as before.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "apply-delta":
+			runApplyDelta(os.Args[2:])
+			return
+		case "history":
+			runHistory(os.Args[2:])
+			return
+		case "scrape-torrents":
+			runScrapeTorrents(os.Args[2:])
+			return
+		case "enrich":
+			runEnrich(os.Args[2:])
+			return
+		case "import-translations":
+			runImportTranslations(os.Args[2:])
+			return
+		case "import-ids":
+			runImportIDs(os.Args[2:])
+			return
+		case "merge-tags":
+			runMergeTags(os.Args[2:])
+			return
+		case "search":
+			runSearch(os.Args[2:])
+			return
+		case "watch-test":
+			runWatchTest(os.Args[2:])
+			return
+		case "create-fulltext":
+			runCreateFullText(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "daemon":
+			runDaemon(os.Args[2:])
+			return
+		}
+	}
+
 	site := flag.String("site", "e-hentai", "Target site: 'e-hentai' or 'exhentai'")
 	offset := flag.Int64("offset", 0, "Static offset (in hours) to adjust the initial fetch starting point.")
 	cookieFile := flag.String("cookie-file", "", "Path to cookie JSON file (required for exhentai)")
-	debug := flag.Bool("debug", false, "Enable debug logging")
 	sleepDuration := flag.Int("sleep-duration", 0, "Override sleep duration between page fetches (in seconds)")
 	onlyExpunged := flag.Bool("only-expunged", false, "Fetch only expunged galleries")
 	alsoExpunged := flag.Bool("also-expunged", false, "Also fetch expunged galleries after normal fetching")
 	search := flag.String("search", "", "Optional keyword to search for")
-
-	dbDriver := flag.String("db-driver", "", "Database driver (mysql or sqlite)")
-	dbHost := flag.String("db-host", "", "Database host")
-	dbPort := flag.String("db-port", "", "Database port")
-	dbUser := flag.String("db-user", "", "Database user")
-	dbPass := flag.String("db-pass", "", "Database password")
-	dbName := flag.String("db-name", "", "Database name (or SQLite filename)")
-	sqlitePath := flag.String("sqlite-path", "", "SQLite database file path")
+	from := flag.String("from", "", "Only crawl galleries from this gid or date (YYYY-MM-DD, UTC) on")
+	to := flag.String("to", "", "Only crawl galleries up to this gid, or posted before this date (YYYY-MM-DD, UTC)")
+	backward := flag.Bool("backward", false, "With --from/--to, crawl from the upper bound down to the lower one")
+	profiles := flag.String("profiles", "", "Comma-separated crawl profiles from config.yaml to run instead of the default pass, or 'all'")
+	history := flag.Bool("history", false, "Record field-level gallery changes in the gallery_history table")
+	scrapeTorrents := flag.Bool("scrape-torrents", false, "Also scrape torrent pages of imported galleries (torrent id, uploader, seeders)")
+	enrich := flag.Bool("enrich", false, "Also fetch gallery pages of imported galleries (language, favorites, rating count, visibility)")
+	enrichDims := flag.Bool("enrich-dimensions", false, "Like --enrich, and also fetch each gallery's first image page for its page dimensions")
+	metricsFile := flag.String("metrics-file", "", "Write Prometheus metrics of the run to this file (for the node_exporter textfile collector)")
+	reportFile := flag.String("report", "", "Write a JSON report of the run to this file, or '-' for stdout")
+	dbf := registerDBFlags(flag.CommandLine)
 
 	flag.Parse()
-	debugMode = *debug
-
-	// Override viper config with command line arguments if provided
-	if *dbDriver != "" {
-		viper.Set("database.driver", *dbDriver)
+	dbf.apply()
+	if *reportFile == "-" {
+		logToStderr()
 	}
-	if *dbHost != "" {
-		viper.Set("database.host", *dbHost)
-	}
-	if *dbPort != "" {
-		viper.Set("database.port", *dbPort)
-	}
-	if *dbUser != "" {
-		viper.Set("database.user", *dbUser)
-	}
-	if *dbPass != "" {
-		viper.Set("database.password", *dbPass)
+
+	if *profiles != "" && (*search != "" || *offset != 0 || *onlyExpunged || *alsoExpunged) {
+		errorLog("--profiles can't be combined with --search, --offset, --only-expunged or --also-expunged")
+		os.Exit(1)
 	}
-	if *dbName != "" {
-		viper.Set("database.name", *dbName)
+	bounded := *from != "" || *to != ""
+	if *backward && !bounded {
+		errorLog("--backward needs --from or --to")
+		os.Exit(1)
 	}
-	if *sqlitePath != "" {
-		viper.Set("database.sqlite_path", *sqlitePath)
+	if bounded && (*offset != 0 || *profiles != "") {
+		errorLog("--from and --to can't be combined with --offset or --profiles")
+		os.Exit(1)
 	}
+
 	if *sleepDuration > 0 {
 		viper.Set("sleep_duration", *sleepDuration)
 	}
+	if *history {
+		viper.Set("history", true)
+	}
 
 	opts := Options{
-		Site:         *site,
-		Offset:       *offset,
-		CookieFile:   *cookieFile,
-		OnlyExpunged: *onlyExpunged,
-		AlsoExpunged: *alsoExpunged,
-		Search:       *search,
+		Site:           *site,
+		Offset:         *offset,
+		CookieFile:     *cookieFile,
+		OnlyExpunged:   *onlyExpunged,
+		AlsoExpunged:   *alsoExpunged,
+		Search:         *search,
+		ScrapeTorrents: *scrapeTorrents,
+		Enrich:         *enrich,
+		EnrichDims:     *enrichDims,
+		From:           *from,
+		To:             *to,
+		Backward:       *backward,
+	}
+	for _, name := range strings.Split(*profiles, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Profiles = append(opts.Profiles, name)
+		}
+	}
+	if *metricsFile != "" {
+		opts.Metrics = NewMetrics()
 	}
 
 	instance := NewSync(opts)
-	if err := instance.run(); err != nil {
+	instance.initNewestPosted()
+	if *reportFile != "" {
+		instance.report = NewRunReport(instance.host, "")
+	}
+	err := instance.run()
+	instance.closeWatches()
+	if err == nil {
+		instance.metrics.syncSucceeded()
+	}
+	if *metricsFile != "" {
+		if err := instance.metrics.writeFile(*metricsFile); err != nil {
+			errorLog("Error: %v", err)
+		}
+	}
+	if *reportFile != "" {
+		sum, sumErr := instance.databaseSummary()
+		if sumErr != nil {
+			warnLog("Report: %v", sumErr)
+		}
+		instance.report.finish(err, sum)
+		if err := instance.report.write(*reportFile); err != nil {
+			errorLog("Error: %v", err)
+		}
+	}
+	if err != nil {
 		errorLog("Error: %v", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// --- Tag Namespaces ---
+
+// miscNamespace is the namespace of tags the API returns without a prefix.
+const miscNamespace = "misc"
+
+// tagNamespaceColumns split tag.name ("female:glasses") into its namespace and
+// value so tags can be looked up by namespace through an index.
+var tagNamespaceColumns = []columnDef{
+	{"namespace", "VARCHAR(20) DEFAULT NULL"},
+	{"value", "VARCHAR(200) DEFAULT NULL"},
+}
+
+// splitTag splits a combined tag name into namespace and value. Tags without a
+// namespace belong to misc.
+func splitTag(name string) (string, string) {
+	if i := strings.Index(name, ":"); i > 0 {
+		return name[:i], name[i+1:]
+	}
+	return miscNamespace, name
+}
+
+// ensureTagNamespaces adds the namespace and value columns and fills them for
+// tags stored before they existed. INSTR and SUBSTR behave the same on MySQL
+// and SQLite.
+func (st *Store) ensureTagNamespaces() error {
+	if err := st.ensureColumns("tag", tagNamespaceColumns); err != nil {
+		return err
+	}
+	if err := st.ensureIndex("tag", "tag_namespace_value", "namespace", "value"); err != nil {
+		return err
+	}
+	res, err := st.db.Exec("UPDATE tag SET " +
+		"namespace = CASE WHEN INSTR(name, ':') > 1 THEN SUBSTR(name, 1, INSTR(name, ':') - 1) ELSE '" + miscNamespace + "' END, " +
+		"value = CASE WHEN INSTR(name, ':') > 1 THEN SUBSTR(name, INSTR(name, ':') + 1) ELSE name END " +
+		"WHERE namespace IS NULL")
+	if err != nil {
+		return fmt.Errorf("filling tag namespaces: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		infoLog("Filled namespace and value of %d tags", n)
+	}
+	return nil
+}
+
+// --- Tag Aliases ---
+
+// tagAliasDDL creates tag_alias, which maps old or slave tag names to the id of
+// their canonical tag.
+var tagAliasDDL = map[string][]string{
+	"mysql": {
+		"CREATE TABLE IF NOT EXISTS `tag_alias` (" +
+			"`alias` varchar(200) NOT NULL, " +
+			"`tid` int(11) NOT NULL, " +
+			"PRIMARY KEY (`alias`), KEY `tid` (`tid`)" +
+			") ENGINE=MyISAM DEFAULT CHARSET=utf8mb4",
+	},
+	"sqlite3": {
+		"CREATE TABLE IF NOT EXISTS tag_alias (alias TEXT PRIMARY KEY, tid INTEGER NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS tag_alias_tid ON tag_alias (tid)",
+	},
+}
+
+// loadTagAliases returns the alias table as alias name → canonical tag name.
+func (st *Store) loadTagAliases() (map[string]string, error) {
+	if !st.hasTagAliases {
+		return map[string]string{}, nil
+	}
+	rows, err := st.db.Query("SELECT a.alias, t.name FROM tag_alias a JOIN tag t ON t.id = a.tid")
+	if err != nil {
+		return nil, fmt.Errorf("querying tag aliases: %w", err)
+	}
+	defer rows.Close()
+	aliases := make(map[string]string)
+	for rows.Next() {
+		var alias, name string
+		if err := rows.Scan(&alias, &name); err != nil {
+			return nil, fmt.Errorf("scanning tag alias: %w", err)
+		}
+		aliases[alias] = name
+	}
+	return aliases, rows.Err()
+}
+
+// canonicalTags replaces aliased tag names by their canonical tag, dropping
+// duplicates this may create.
+func canonicalTags(tags []string, aliases map[string]string) []string {
+	if len(aliases) == 0 {
+		return tags
+	}
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, name := range tags {
+		if canonical, ok := aliases[name]; ok {
+			name = canonical
+		}
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// addTagAlias records alias as another name of canonical, creating the canonical
+// tag if needed. If canonical is itself an alias, its target is used.
+func (st *Store) addTagAlias(alias, canonical string) error {
+	if alias == canonical {
+		return fmt.Errorf("tag '%s' can't be an alias of itself", alias)
+	}
+	var tid int
+	err := st.db.QueryRow("SELECT tid FROM tag_alias WHERE alias = ?", canonical).Scan(&tid)
+	if err == sql.ErrNoRows {
+		if tid, err = st.tagID(canonical); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("querying alias '%s': %w", canonical, err)
+	}
+	if _, err := st.db.Exec(st.upsertSQL("tag_alias", "alias", []string{"alias", "tid"}, []string{"tid"}), alias, tid); err != nil {
+		return fmt.Errorf("saving alias '%s': %w", alias, err)
+	}
+	return nil
+}
+
+// unlinkTag removes the link between a gallery and the named tag.
+func (st *Store) unlinkTag(gid int, tagName string) error {
+	namespace, value := splitTag(tagName)
+	_, err := st.db.Exec("DELETE FROM gid_tid WHERE gid = ? AND tid IN (SELECT id FROM tag WHERE namespace = ? AND value = ?)", gid, namespace, value)
+	if err != nil {
+		return fmt.Errorf("unlinking tag '%s' from gid %d: %w", tagName, gid, err)
+	}
+	debugLog("Unlinked tag '%s' from gid %d", tagName, gid)
+	return nil
+}
+
+// tagMerge is an aliased tag that still exists and is to be merged into its
+// canonical tag.
+type tagMerge struct {
+	From     int
+	FromName string
+	ToName   string
+}
+
+// tagMerges returns every alias, from the alias table and extra (alias →
+// canonical name), that still exists as a tag of its own.
+func (st *Store) tagMerges(extra map[string]string) ([]tagMerge, error) {
+	aliases, err := st.loadTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	for alias, canonical := range extra {
+		aliases[alias] = canonical
+	}
+	names := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+
+	var merges []tagMerge
+	for _, alias := range names {
+		namespace, value := splitTag(alias)
+		var id int
+		err := st.db.QueryRow("SELECT id FROM tag WHERE namespace = ? AND value = ?", namespace, value).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("querying tag '%s': %w", alias, err)
+		}
+		merges = append(merges, tagMerge{From: id, FromName: alias, ToName: aliases[alias]})
+	}
+	return merges, nil
+}
+
+// mergeTag moves every gallery of tag from to tag to, carries over translations
+// the target lacks, re-points aliases and deletes the old tag. Affected galleries
+// are recorded in the change log. It returns the number of affected galleries.
+func (st *Store) mergeTag(from, to int) (int, error) {
+	rows, err := st.db.Query("SELECT DISTINCT gid FROM gid_tid WHERE tid = ?", from)
+	if err != nil {
+		return 0, fmt.Errorf("querying galleries of tag %d: %w", from, err)
+	}
+	var gids []int
+	for rows.Next() {
+		var gid int
+		if err := rows.Scan(&gid); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning gallery: %w", err)
+		}
+		gids = append(gids, gid)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("reading galleries of tag %d: %w", from, err)
+	}
+
+	// The derived tables keep MySQL from rejecting a subquery on the updated table.
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM gid_tid WHERE tid = ? AND gid IN (SELECT gid FROM (SELECT gid FROM gid_tid WHERE tid = ?) x)", []interface{}{from, to}},
+		{"UPDATE gid_tid SET tid = ? WHERE tid = ?", []interface{}{to, from}},
+		{"UPDATE tag_translation SET tid = ? WHERE tid = ? AND lang NOT IN (SELECT lang FROM (SELECT lang FROM tag_translation WHERE tid = ?) x)", []interface{}{to, from, to}},
+		{"DELETE FROM tag_translation WHERE tid = ?", []interface{}{from}},
+		{"UPDATE tag_alias SET tid = ? WHERE tid = ?", []interface{}{to, from}},
+		{"DELETE FROM tag WHERE id = ?", []interface{}{from}},
+	}
+	for _, stmt := range stmts {
+		if _, err := st.db.Exec(stmt.query, stmt.args...); err != nil {
+			return 0, fmt.Errorf("merging tag %d into %d: %w", from, to, err)
+		}
+	}
+	for _, gid := range gids {
+		if err := st.recordChange(gid); err != nil {
+			return len(gids), err
+		}
+	}
+	return len(gids), nil
+}
+
+// orphanTags returns the tags no gallery links to, except alias targets.
+func (st *Store) orphanTags() (map[int]string, error) {
+	rows, err := st.db.Query("SELECT t.id, t.name FROM tag t LEFT JOIN gid_tid gt ON gt.tid = t.id " +
+		"WHERE gt.tid IS NULL AND t.id NOT IN (SELECT tid FROM tag_alias)")
+	if err != nil {
+		return nil, fmt.Errorf("querying orphan tags: %w", err)
+	}
+	defer rows.Close()
+	orphans := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("scanning tag: %w", err)
+		}
+		orphans[id] = name
+	}
+	return orphans, rows.Err()
+}
+
+func (st *Store) deleteTag(id int) error {
+	for _, query := range []string{"DELETE FROM tag_translation WHERE tid = ?", "DELETE FROM tag WHERE id = ?"} {
+		if _, err := st.db.Exec(query, id); err != nil {
+			return fmt.Errorf("deleting tag %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// readAliasFile reads "alias=canonical" lines; blank lines and # comments are skipped.
+func readAliasFile(path string, aliases map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		alias, canonical, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected alias=canonical", path, line)
+		}
+		aliases[strings.TrimSpace(alias)] = strings.TrimSpace(canonical)
+	}
+	return scanner.Err()
+}
+
+func runMergeTags(args []string) {
+	fs := flag.NewFlagSet("merge-tags", flag.ExitOnError)
+	aliases := make(map[string]string)
+	fs.Func("alias", "Record a rename as 'old=new' (e.g. 'female:megane=female:glasses'); may be repeated", func(v string) error {
+		alias, canonical, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("expected old=new")
+		}
+		aliases[strings.TrimSpace(alias)] = strings.TrimSpace(canonical)
+		return nil
+	})
+	aliasFile := fs.String("alias-file", "", "File with one 'old=new' rename per line")
+	prune := fs.Bool("prune", false, "Delete tags no gallery links to anymore")
+	dryRun := fs.Bool("dry-run", false, "Only report what would be merged and pruned")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	if *aliasFile != "" {
+		if err := readAliasFile(*aliasFile, aliases); err != nil {
+			errorLog("Error reading alias file: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	st := openStore(loadConfig())
+	if err := st.ensureSchema(); err != nil {
+		errorLog("Error preparing schema: %v", err)
+		os.Exit(1)
+	}
+	if err := st.ensureTables(tagTranslationDDL); err != nil {
+		errorLog("Error preparing translation table: %v", err)
+		os.Exit(1)
+	}
+	if err := st.setNames(); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+
+	var extra map[string]string
+	if *dryRun {
+		extra = aliases
+	} else {
+		for alias, canonical := range aliases {
+			if err := st.addTagAlias(alias, canonical); err != nil {
+				errorLog("Error: %v", err)
+				os.Exit(1)
+			}
+		}
+	}
+	merges, err := st.tagMerges(extra)
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	for _, m := range merges {
+		if *dryRun {
+			infoLog("Would merge '%s' into '%s'", m.FromName, m.ToName)
+			continue
+		}
+		to, err := st.tagID(m.ToName)
+		if err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+		if to == m.From {
+			continue
+		}
+		n, err := st.mergeTag(m.From, to)
+		if err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+		infoLog("Merged '%s' into '%s' (%d galleries)", m.FromName, m.ToName, n)
+	}
+
+	if *prune {
+		orphans, err := st.orphanTags()
+		if err != nil {
+			errorLog("Error: %v", err)
+			os.Exit(1)
+		}
+		for id, name := range orphans {
+			if *dryRun {
+				infoLog("Would delete unused tag '%s'", name)
+				continue
+			}
+			if err := st.deleteTag(id); err != nil {
+				errorLog("Error: %v", err)
+				os.Exit(1)
+			}
+			debugLog("Deleted unused tag '%s'", name)
+		}
+		if !*dryRun {
+			infoLog("Deleted %d unused tags", len(orphans))
+		}
+	}
+	if !*dryRun {
+		infoLog("Merged %d aliased tags", len(merges))
+	}
+}
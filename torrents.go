@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Torrent Page Scraping ---
+
+// TorrentPageEntry is one torrent as listed on a gallery's gallerytorrents.php page.
+type TorrentPageEntry struct {
+	ID        int
+	Hash      string
+	Name      string
+	Uploader  string
+	Posted    int64 // unix seconds
+	Size      string
+	Seeders   int
+	Leechers  int
+	Downloads int
+}
+
+// torrentStatColumns hold the statistics only available from the torrent page.
+var torrentStatColumns = []columnDef{
+	{"seeders", "INT DEFAULT NULL"},
+	{"leechers", "INT DEFAULT NULL"},
+	{"downloads", "INT DEFAULT NULL"},
+}
+
+var (
+	torrentIDRe        = regexp.MustCompile(`name="gtid" value="(\d+)"`)
+	torrentHashRe      = regexp.MustCompile(`/([0-9a-f]{40})\.torrent`)
+	torrentNameRe      = regexp.MustCompile(`\.torrent[^>]*>([^<]+)</a>`)
+	torrentPostedRe    = regexp.MustCompile(`Posted:</span>\s*(?:<span>)?\s*(\d{4}-\d{2}-\d{2}\s\d{2}:\d{2})`)
+	torrentSizeRe      = regexp.MustCompile(`Size:</span>\s*(?:<span>)?\s*([^<]+)`)
+	torrentSeedsRe     = regexp.MustCompile(`Seeds:</span>\s*(?:<span>)?\s*(\d+)`)
+	torrentPeersRe     = regexp.MustCompile(`Peers:</span>\s*(?:<span>)?\s*(\d+)`)
+	torrentDownloadsRe = regexp.MustCompile(`Downloads:</span>\s*(?:<span>)?\s*(\d+)`)
+	torrentUploaderRe  = regexp.MustCompile(`Uploader:</span>\s*(?:<span>)?\s*([^<]+)`)
+)
+
+// parseTorrentPage extracts the torrents from a gallerytorrents.php page. Each
+// torrent is rendered in its own form, which is used to split the page.
+func parseTorrentPage(body string) []TorrentPageEntry {
+	var list []TorrentPageEntry
+	for _, block := range strings.Split(body, "<form")[1:] {
+		m := torrentHashRe.FindStringSubmatch(block)
+		if m == nil {
+			continue
+		}
+		t := TorrentPageEntry{Hash: m[1]}
+		submatch := func(re *regexp.Regexp) string {
+			if m := re.FindStringSubmatch(block); m != nil {
+				return strings.TrimSpace(html.UnescapeString(m[1]))
+			}
+			return ""
+		}
+		t.ID, _ = strconv.Atoi(submatch(torrentIDRe))
+		t.Name = submatch(torrentNameRe)
+		t.Uploader = submatch(torrentUploaderRe)
+		t.Size = submatch(torrentSizeRe)
+		t.Seeders, _ = strconv.Atoi(submatch(torrentSeedsRe))
+		t.Leechers, _ = strconv.Atoi(submatch(torrentPeersRe))
+		t.Downloads, _ = strconv.Atoi(submatch(torrentDownloadsRe))
+		if posted, err := time.Parse("2006-01-02 15:04", submatch(torrentPostedRe)); err == nil {
+			t.Posted = posted.Unix()
+		}
+		list = append(list, t)
+	}
+	return list
+}
+
+// saveScrapedTorrent updates the torrent row matching gid and hash with the data
+// from the torrent page, inserting it first if the API didn't list it. It reports
+// whether the row was inserted or any of its fields changed.
+func (st *Store) saveScrapedTorrent(gid int, t TorrentPageEntry) (bool, error) {
+	var id, seeders, leechers, downloads int
+	var uploader string
+	var added int64
+	err := st.db.QueryRow("SELECT COALESCE(id, 0), COALESCE(uploader, ''), COALESCE(seeders, -1), COALESCE(leechers, -1), COALESCE(downloads, -1), "+
+		"COALESCE("+st.unixTimeExpr("added")+", 0) FROM torrent WHERE gid = ? AND hash = ?", gid, t.Hash).
+		Scan(&id, &uploader, &seeders, &leechers, &downloads, &added)
+	switch {
+	case err == sql.ErrNoRows:
+		info := TorrentInfo{Hash: t.Hash, Name: t.Name, Fsize: t.Size}
+		if t.Posted > 0 {
+			info.Added = strconv.FormatInt(t.Posted, 10)
+		}
+		if err := st.saveTorrent(gid, info, t.Uploader); err != nil {
+			return false, err
+		}
+	case err != nil:
+		return false, fmt.Errorf("querying torrent %s for gid %d: %w", t.Hash, gid, err)
+	case id == t.ID && uploader == t.Uploader && seeders == t.Seeders && leechers == t.Leechers &&
+		downloads == t.Downloads && (t.Posted == 0 || added == t.Posted):
+		return false, nil
+	}
+	sets := "id = ?, uploader = ?, seeders = ?, leechers = ?, downloads = ?"
+	args := []interface{}{t.ID, t.Uploader, t.Seeders, t.Leechers, t.Downloads}
+	if t.Posted > 0 {
+		// Keep the API's time when the posted line couldn't be read.
+		sets += ", added = " + st.fromUnixTimeExpr()
+		args = append(args, t.Posted)
+	}
+	if _, err := st.db.Exec("UPDATE torrent SET "+sets+" WHERE gid = ? AND hash = ?", append(args, gid, t.Hash)...); err != nil {
+		return true, fmt.Errorf("updating torrent %s for gid %d: %w", t.Hash, gid, err)
+	}
+	return true, nil
+}
+
+// scrapeGalleryTorrents fetches the torrent page of a gallery and updates its
+// torrent rows. bytorrent is set once the page listed all torrents the API
+// counts; a login or error page lists none and leaves the gallery pending.
+// Galleries whose torrents or bytorrent changed are recorded in the change log.
+func (s *Sync) scrapeGalleryTorrents(gid int, token string) error {
+	fetchURL := fmt.Sprintf("https://%s/gallerytorrents.php?gid=%d&t=%s", s.host, gid, token)
+	body, err := s.fetchPage(fetchURL)
+	if err != nil {
+		return fmt.Errorf("fetching torrent page for gid %d: %w", gid, err)
+	}
+	var torrentcount int
+	var bytorrent int
+	if err := s.db.QueryRow("SELECT torrentcount, bytorrent FROM gallery WHERE gid = ?", gid).Scan(&torrentcount, &bytorrent); err != nil {
+		return fmt.Errorf("querying torrentcount for gid %d: %w", gid, err)
+	}
+	torrents := parseTorrentPage(body)
+	changed := false
+	var saveErr error
+	for _, t := range torrents {
+		var saved bool
+		saved, saveErr = s.saveScrapedTorrent(gid, t)
+		changed = changed || saved
+		if saveErr != nil {
+			break
+		}
+	}
+	complete := saveErr == nil && len(torrents) > 0 && len(torrents) >= torrentcount
+	if complete && bytorrent == 0 {
+		if _, err := s.db.Exec("UPDATE gallery SET bytorrent = 1 WHERE gid = ?", gid); err != nil {
+			return fmt.Errorf("updating bytorrent for gid %d: %w", gid, err)
+		}
+		changed = true
+	}
+	if changed {
+		if err := s.recordChange(gid); err != nil {
+			return err
+		}
+	}
+	if saveErr != nil {
+		return saveErr
+	}
+	if !complete {
+		return fmt.Errorf("torrent page of gid %d lists %d of %d torrents", gid, len(torrents), torrentcount)
+	}
+	debugLog("Scraped %d torrents for gid %d", len(torrents), gid)
+	return nil
+}
+
+// scrapeTorrentsFor scrapes the torrent pages of freshly imported galleries that have torrents.
+func (s *Sync) scrapeTorrentsFor(galleries []GalleryMetadata) {
+	for _, gallery := range galleries {
+		if gallery.Torrentcount == 0 {
+			continue
+		}
+		time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
+		if err := s.scrapeGalleryTorrents(gallery.Gid, gallery.Token); err != nil {
+			errorLog("Error scraping torrents: %v", err)
+		}
+	}
+}
+
+// scrapePendingTorrents walks stored galleries with torrents, newest first, and
+// scrapes their torrent pages. Unless all is set, galleries already scraped
+// (bytorrent = 1) are skipped. A limit of 0 means no limit.
+func (s *Sync) scrapePendingTorrents(limit int, all bool) error {
+	where := "torrentcount > 0"
+	if !all {
+		where += " AND bytorrent = 0"
+	}
+	scraped := 0
+	err := s.walkGalleries(where, func(gid int, token string) (bool, error) {
+		if limit > 0 && scraped >= limit {
+			return false, nil
+		}
+		time.Sleep(time.Duration(s.config.SleepDuration) * time.Second)
+		if err := s.scrapeGalleryTorrents(gid, token); err != nil {
+			errorLog("Error scraping torrents: %v", err)
+			return true, nil
+		}
+		scraped++
+		return true, nil
+	})
+	infoLog("Scraped torrent pages of %d galleries", scraped)
+	return err
+}
+
+func runScrapeTorrents(args []string) {
+	fs := flag.NewFlagSet("scrape-torrents", flag.ExitOnError)
+	site := fs.String("site", "e-hentai", "Target site: 'e-hentai' or 'exhentai'")
+	cookieFile := fs.String("cookie-file", "", "Path to cookie JSON file (required for exhentai)")
+	limit := fs.Int("limit", 0, "Maximum number of galleries to scrape (0 for no limit)")
+	all := fs.Bool("all", false, "Also re-scrape galleries whose torrents were already scraped")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	s := NewSync(Options{Site: *site, CookieFile: *cookieFile})
+	if err := s.setNames(); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	if err := s.scrapePendingTorrents(*limit, *all); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// --- Tag Translations ---
+
+// tagTranslationDDL creates tag_translation, which holds localized names and
+// descriptions of tags, one row per tag and language.
+var tagTranslationDDL = map[string][]string{
+	"mysql": {
+		"CREATE TABLE IF NOT EXISTS `tag_translation` (" +
+			"`tid` int(11) NOT NULL, " +
+			"`lang` varchar(16) NOT NULL, " +
+			"`name` varchar(255) NOT NULL, " +
+			"`intro` text, " +
+			"`links` text, " +
+			"PRIMARY KEY (`tid`,`lang`)" +
+			") ENGINE=MyISAM DEFAULT CHARSET=utf8mb4",
+	},
+	"sqlite3": {
+		"CREATE TABLE IF NOT EXISTS tag_translation (tid INTEGER NOT NULL, lang TEXT NOT NULL, name TEXT NOT NULL, intro TEXT, links TEXT, PRIMARY KEY (tid, lang))",
+	},
+}
+
+// TagTranslation is one row of a tag translation database.
+type TagTranslation struct {
+	Namespace string
+	Value     string // the original (English) tag
+	Name      string // translated name
+	Intro     string
+	Links     string
+}
+
+// ehTagDatabase is the layout of the EhTagTranslation release files
+// (db.text.json, db.raw.json, db.html.json).
+type ehTagDatabase struct {
+	Data []struct {
+		Namespace string `json:"namespace"`
+		Data      map[string]struct {
+			Name  string `json:"name"`
+			Intro string `json:"intro"`
+			Links string `json:"links"`
+		} `json:"data"`
+	} `json:"data"`
+}
+
+// parseTranslationJSON reads an EhTagTranslation release database. The "rows"
+// section translates namespace names rather than tags and is skipped.
+func parseTranslationJSON(r io.Reader) ([]TagTranslation, error) {
+	var db ehTagDatabase
+	if err := json.NewDecoder(r).Decode(&db); err != nil {
+		return nil, fmt.Errorf("decoding translation database: %w", err)
+	}
+	var list []TagTranslation
+	for _, ns := range db.Data {
+		if ns.Namespace == "rows" {
+			continue
+		}
+		values := make([]string, 0, len(ns.Data))
+		for value := range ns.Data {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			t := ns.Data[value]
+			list = append(list, TagTranslation{Namespace: ns.Namespace, Value: value, Name: t.Name, Intro: t.Intro, Links: t.Links})
+		}
+	}
+	return list, nil
+}
+
+var (
+	markdownImageRe     = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	markdownSeparatorRe = regexp.MustCompile(`^\|?(\s*:?-+:?\s*\|)+\s*:?-*:?\s*$`)
+	frontMatterKeyRe    = regexp.MustCompile(`^key:\s*(\S+)`)
+)
+
+// splitMarkdownRow splits a markdown table row into its cells, honouring \| escapes.
+func splitMarkdownRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// parseTranslationMarkdown reads one namespace file of the EhTagTranslation
+// database (e.g. female.md): a table of raw tag, name, intro and links. The
+// namespace is taken from the front matter key, falling back to namespace.
+func parseTranslationMarkdown(r io.Reader, namespace string) ([]TagTranslation, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	var rows [][]string
+	inFrontMatter := false
+	line := 0
+	for scanner.Scan() {
+		text := scanner.Text()
+		line++
+		if line == 1 && strings.TrimSpace(text) == "---" {
+			inFrontMatter = true
+			continue
+		}
+		if inFrontMatter {
+			if strings.TrimSpace(text) == "---" {
+				inFrontMatter = false
+			} else if m := frontMatterKeyRe.FindStringSubmatch(text); m != nil {
+				namespace = m[1]
+			}
+			continue
+		}
+		if !strings.HasPrefix(strings.TrimSpace(text), "|") {
+			continue
+		}
+		if markdownSeparatorRe.MatchString(strings.TrimSpace(text)) {
+			// The row above the separator is the table header.
+			if len(rows) > 0 {
+				rows = rows[:len(rows)-1]
+			}
+			continue
+		}
+		rows = append(rows, splitMarkdownRow(text))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("unknown namespace: no front matter key")
+	}
+
+	var list []TagTranslation
+	for _, cells := range rows {
+		if len(cells) < 2 || cells[0] == "" {
+			continue
+		}
+		t := TagTranslation{Namespace: namespace, Value: cells[0]}
+		t.Name = strings.TrimSpace(markdownImageRe.ReplaceAllString(cells[1], ""))
+		if len(cells) > 2 {
+			t.Intro = cells[2]
+		}
+		if len(cells) > 3 {
+			t.Links = cells[3]
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// readTranslationFiles parses a JSON release database, a single markdown file or
+// a directory of markdown files.
+func readTranslationFiles(path string) ([]TagTranslation, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.md")); err != nil {
+			return nil, err
+		}
+	}
+	var list []TagTranslation
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		var entries []TagTranslation
+		if strings.EqualFold(filepath.Ext(file), ".json") {
+			entries, err = parseTranslationJSON(f)
+		} else {
+			base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+			if info.IsDir() && strings.EqualFold(base, "readme") {
+				f.Close()
+				continue
+			}
+			entries, err = parseTranslationMarkdown(f, base)
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		list = append(list, entries...)
+	}
+	return list, nil
+}
+
+// importTranslations stores translations for tags already present in the tag
+// table. It returns how many were stored and how many had no matching tag.
+func (st *Store) importTranslations(lang string, entries []TagTranslation) (int, int, error) {
+	byNamespace := make(map[string][]TagTranslation)
+	for _, e := range entries {
+		byNamespace[e.Namespace] = append(byNamespace[e.Namespace], e)
+	}
+
+	tx, err := st.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	upsert := st.upsertSQL("tag_translation", "tid, lang", []string{"tid", "lang", "name", "intro", "links"}, []string{"name", "intro", "links"})
+
+	imported, skipped := 0, 0
+	for namespace, list := range byNamespace {
+		ids := make(map[string]int)
+		rows, err := tx.Query("SELECT id, value FROM tag WHERE namespace = ?", namespace)
+		if err != nil {
+			return imported, skipped, fmt.Errorf("querying tags in %s: %w", namespace, err)
+		}
+		for rows.Next() {
+			var id int
+			var value string
+			if err := rows.Scan(&id, &value); err != nil {
+				rows.Close()
+				return imported, skipped, fmt.Errorf("scanning tag: %w", err)
+			}
+			ids[value] = id
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return imported, skipped, fmt.Errorf("reading tags in %s: %w", namespace, err)
+		}
+
+		for _, e := range list {
+			tid, ok := ids[e.Value]
+			if !ok {
+				skipped++
+				continue
+			}
+			if _, err := tx.Exec(upsert, tid, lang, e.Name, e.Intro, e.Links); err != nil {
+				return imported, skipped, fmt.Errorf("saving translation of %s:%s: %w", e.Namespace, e.Value, err)
+			}
+			imported++
+		}
+		debugLog("Imported %d translations in namespace %s", len(list), namespace)
+	}
+	return imported, skipped, tx.Commit()
+}
+
+// attachTagTranslations fills TagTranslations of a chunk of galleries with the
+// translated names of their tags in lang. Untranslated tags are left out, as
+// are all of them when the database has no translations.
+func (st *Store) attachTagTranslations(chunk []*GalleryRecord, lang string) error {
+	if !st.hasTranslations {
+		return nil
+	}
+	byGid := make(map[int]*GalleryRecord, len(chunk))
+	args := []interface{}{lang}
+	for _, r := range chunk {
+		byGid[r.Gid] = r
+		args = append(args, r.Gid)
+	}
+	rows, err := st.db.Query("SELECT gt.gid, t.name, tt.name FROM gid_tid gt JOIN tag t ON t.id = gt.tid JOIN tag_translation tt ON tt.tid = gt.tid AND tt.lang = ? WHERE gt.gid IN ("+placeholders(len(chunk))+")", args...)
+	if err != nil {
+		return fmt.Errorf("querying tag translations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var gid int
+		var name, translated string
+		if err := rows.Scan(&gid, &name, &translated); err != nil {
+			return fmt.Errorf("scanning tag translation: %w", err)
+		}
+		r := byGid[gid]
+		if r.TagTranslations == nil {
+			r.TagTranslations = make(map[string]string)
+		}
+		r.TagTranslations[name] = translated
+	}
+	return rows.Err()
+}
+
+func runImportTranslations(args []string) {
+	fs := flag.NewFlagSet("import-translations", flag.ExitOnError)
+	input := fs.String("input", "", "Translation database: db.text.json, a namespace .md file or a directory of .md files")
+	lang := fs.String("lang", "zh-CN", "Language code the translations are stored under")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	if *input == "" {
+		errorLog("--input is required")
+		os.Exit(1)
+	}
+	entries, err := readTranslationFiles(*input)
+	if err != nil {
+		errorLog("Error reading translations: %v", err)
+		os.Exit(1)
+	}
+
+	st := openStore(loadConfig())
+	if err := st.ensureSchema(); err != nil {
+		errorLog("Error preparing schema: %v", err)
+		os.Exit(1)
+	}
+	if err := st.ensureTables(tagTranslationDDL); err != nil {
+		errorLog("Error preparing translation table: %v", err)
+		os.Exit(1)
+	}
+	if err := st.setNames(); err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	imported, skipped, err := st.importTranslations(*lang, entries)
+	if err != nil {
+		errorLog("Error importing translations: %v", err)
+		os.Exit(1)
+	}
+	infoLog("Imported %d %s tag translations (%d for tags not in the database)", imported, *lang, skipped)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Watch Rules ---
+
+// WatchRule is a saved search from the watch section of config.yaml. Newly
+// inserted galleries that match it are posted to Webhook and/or appended to
+// File as JSON lines.
+type WatchRule struct {
+	Name      string   `mapstructure:"name"`
+	Query     string   `mapstructure:"query"` // search syntax, e.g. `female:glasses$ -language:chinese`
+	Uploader  string   `mapstructure:"uploader"`
+	Category  []string `mapstructure:"category"`
+	MinRating float64  `mapstructure:"min_rating"`
+	Webhook   string   `mapstructure:"webhook"`
+	File      string   `mapstructure:"file"`
+
+	query SearchQuery
+}
+
+// WatchEvent is what a matching gallery is reported as.
+type WatchEvent struct {
+	Rule    string          `json:"rule"`
+	URL     string          `json:"url"`
+	Gallery GalleryMetadata `json:"gallery"`
+	Time    time.Time       `json:"time"`
+}
+
+// loadWatchRules reads and validates the watch rules of the configuration.
+func loadWatchRules() ([]*WatchRule, error) {
+	var rules []*WatchRule
+	if err := viper.UnmarshalKey("watch", &rules); err != nil {
+		return nil, fmt.Errorf("reading watch rules: %w", err)
+	}
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("watch %d", i+1)
+		}
+		if r.Webhook == "" && r.File == "" {
+			return nil, fmt.Errorf("watch rule %q: needs a webhook or a file", r.Name)
+		}
+		terms, err := parseSearchQuery(r.Query)
+		if err != nil {
+			return nil, fmt.Errorf("watch rule %q: %w", r.Name, err)
+		}
+		if r.Uploader != "" {
+			terms = append(terms, searchTerm{Namespace: "uploader", Value: r.Uploader})
+		}
+		if len(terms) == 0 && len(r.Category) == 0 && r.MinRating == 0 {
+			return nil, fmt.Errorf("watch rule %q: matches every gallery; set a query, uploader, category or min_rating", r.Name)
+		}
+		r.query = SearchQuery{Terms: terms, Categories: r.Category, MinRating: r.MinRating}
+	}
+	return rules, nil
+}
+
+// webhookClient posts watch events; endpoints are usually local, so it doesn't
+// share the site client.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookRetryDelay is multiplied by the attempt number between webhook retries.
+var webhookRetryDelay = time.Second
+
+// watchQueueSize bounds the events waiting for delivery; the import only
+// blocks when the endpoints fall this far behind.
+const watchQueueSize = 256
+
+type watchDelivery struct {
+	rule  *WatchRule
+	event WatchEvent
+}
+
+// watchNotifier delivers watch events in the background, so a slow or failing
+// webhook doesn't hold up the import.
+type watchNotifier struct {
+	queue chan watchDelivery
+	done  chan struct{}
+}
+
+func startWatchNotifier() *watchNotifier {
+	n := &watchNotifier{queue: make(chan watchDelivery, watchQueueSize), done: make(chan struct{})}
+	go func() {
+		defer close(n.done)
+		for d := range n.queue {
+			if err := d.rule.deliver(d.event); err != nil {
+				logFields{"rule", d.rule.Name, "gid", d.event.Gallery.Gid}.errorLog("Error notifying watch rule %q: %v", d.rule.Name, err)
+			}
+		}
+	}()
+	return n
+}
+
+// close waits until the queued events are delivered.
+func (n *watchNotifier) close() {
+	close(n.queue)
+	<-n.done
+}
+
+// siteHost returns the host of a --site value.
+func siteHost(site string) string {
+	if site == "exhentai" {
+		return "exhentai.org"
+	}
+	return "e-hentai.org"
+}
+
+// galleryURL is the gallery page of g on host.
+func galleryURL(host string, g GalleryMetadata) string {
+	return fmt.Sprintf("https://%s/g/%d/%s/", host, g.Gid, g.Token)
+}
+
+// notifyWatches queues the galleries that match a watch rule for delivery.
+// Failures are logged and don't stop the import.
+func (s *Sync) notifyWatches(galleries []GalleryMetadata) {
+	for _, r := range s.watches {
+		for _, g := range galleries {
+			if !r.query.matches(g) {
+				continue
+			}
+			event := WatchEvent{Rule: r.Name, URL: galleryURL(s.host, g), Gallery: g, Time: time.Now().UTC()}
+			logFields{"rule", r.Name, "gid", g.Gid}.infoLog("Gallery %d matches watch rule %q", g.Gid, r.Name)
+			s.notifier.queue <- watchDelivery{rule: r, event: event}
+		}
+	}
+}
+
+// closeWatches waits for the pending watch events before the command exits.
+func (s *Sync) closeWatches() {
+	if s.notifier != nil {
+		s.notifier.close()
+		s.notifier = nil
+	}
+}
+
+// deliver posts event to the rule's webhook and appends it to its file.
+func (r *WatchRule) deliver(event WatchEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	if r.Webhook != "" {
+		if err := postWebhook(r.Webhook, data); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if r.File != "" {
+		if err := appendLine(r.File, data); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func postWebhook(url string, body []byte) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * webhookRetryDelay)
+		}
+		var resp *http.Response
+		resp, err = webhookClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("webhook returned HTTP status code: %d", resp.StatusCode)
+		if resp.StatusCode < 500 {
+			break
+		}
+	}
+	return fmt.Errorf("posting webhook: %w", err)
+}
+
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("writing notification: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing notification: %w", err)
+	}
+	return f.Close()
+}
+
+// --- Watch Test Command ---
+
+// runWatchTest matches the watch rules against the newest stored galleries, so
+// rules and endpoints can be tried without waiting for new uploads.
+func runWatchTest(args []string) {
+	fs := flag.NewFlagSet("watch-test", flag.ExitOnError)
+	limit := fs.Int("limit", 100, "Number of newest stored galleries to match")
+	send := fs.Bool("send", false, "Deliver the matches to the rules' webhooks and files")
+	site := fs.String("site", "e-hentai", "Site used for gallery links: 'e-hentai' or 'exhentai'")
+	dbf := registerDBFlags(fs)
+	fs.Parse(args)
+	dbf.apply()
+
+	st := openReadOnlyStore()
+	rules, err := loadWatchRules()
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	if len(rules) == 0 {
+		errorLog("No watch rules are configured")
+		os.Exit(1)
+	}
+	aliases, err := st.loadTagAliases()
+	if err != nil {
+		errorLog("Error loading tag aliases: %v", err)
+		os.Exit(1)
+	}
+	host := siteHost(*site)
+
+	results, err := st.searchGalleries(SearchQuery{Expunged: true}, false, *limit, 0)
+	if err != nil {
+		errorLog("Error: %v", err)
+		os.Exit(1)
+	}
+	matched := 0
+	for _, r := range rules {
+		for _, rec := range results {
+			g := rec.GalleryMetadata
+			g.Tags = canonicalTags(g.Tags, aliases)
+			if !r.query.matches(g) {
+				continue
+			}
+			matched++
+			infoLog("%s: %d %s", r.Name, g.Gid, g.Title)
+			if *send {
+				if err := r.deliver(WatchEvent{Rule: r.Name, URL: galleryURL(host, g), Gallery: g, Time: time.Now().UTC()}); err != nil {
+					errorLog("Error notifying watch rule %q: %v", r.Name, err)
+				}
+			}
+		}
+	}
+	infoLog("%d matches in the newest %d galleries", matched, len(results))
+}
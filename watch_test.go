@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	webhookRetryDelay = time.Millisecond
+}
+
+func TestPostWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int // returned in turn; the last one repeats
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"ok", []int{http.StatusOK}, 1, false},
+		{"no content", []int{http.StatusNoContent}, 1, false},
+		{"retry after 5xx", []int{http.StatusBadGateway, http.StatusOK}, 2, false},
+		{"give up after 3 attempts", []int{http.StatusInternalServerError}, 3, true},
+		{"no retry after 4xx", []int{http.StatusNotFound}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type = %q", ct)
+				}
+				if body, _ := io.ReadAll(r.Body); string(body) != `{"a":1}` {
+					t.Errorf("body = %q", body)
+				}
+				i := int(n) - 1
+				if i >= len(tt.statuses) {
+					i = len(tt.statuses) - 1
+				}
+				w.WriteHeader(tt.statuses[i])
+			}))
+			defer srv.Close()
+
+			err := postWebhook(srv.URL, []byte(`{"a":1}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("postWebhook error = %v, want error %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("%d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWatchRuleDeliver(t *testing.T) {
+	var got WatchEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding webhook body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "watch.jsonl")
+	r := &WatchRule{Name: "glasses", Webhook: srv.URL, File: file}
+	g := GalleryMetadata{Gid: 618395, Token: "0439fa3666", Title: "Some Title"}
+	event := WatchEvent{Rule: r.Name, URL: galleryURL("e-hentai.org", g), Gallery: g}
+	for i := 0; i < 2; i++ {
+		if err := r.deliver(event); err != nil {
+			t.Fatalf("deliver: %v", err)
+		}
+	}
+
+	if got.Rule != "glasses" || got.Gallery.Gid != 618395 || got.URL != "https://e-hentai.org/g/618395/0439fa3666/" {
+		t.Errorf("webhook received %+v", got)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("file has %d lines, want 2", len(lines))
+	}
+	var line WatchEvent
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil || line.Gallery.Title != "Some Title" {
+		t.Errorf("file line %q: %v", lines[1], err)
+	}
+}
+
+func TestWatchRuleDeliverErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	r := &WatchRule{Name: "broken", Webhook: srv.URL, File: filepath.Join(t.TempDir(), "missing", "watch.jsonl")}
+	err := r.deliver(WatchEvent{Rule: r.Name})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "writing notification") {
+		t.Errorf("error %q should report both the webhook and the file", err)
+	}
+}
+
+func TestNotifyWatchesQueues(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer srv.Close()
+
+	terms, err := parseSearchQuery("female:glasses$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Sync{
+		host:     "e-hentai.org",
+		watches:  []*WatchRule{{Name: "glasses", Webhook: srv.URL, query: SearchQuery{Terms: terms}}},
+		notifier: startWatchNotifier(),
+	}
+	galleries := []GalleryMetadata{
+		{Gid: 1, Tags: []string{"female:glasses"}},
+		{Gid: 2, Tags: []string{"female:big breasts"}},
+		{Gid: 3, Tags: []string{"female:glasses"}},
+	}
+
+	// The webhook blocks until released, so notifyWatches must return first.
+	s.notifyWatches(galleries)
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("%d webhooks delivered before release", n)
+	}
+	close(release)
+	s.closeWatches()
+	if calls != 2 {
+		t.Errorf("%d webhooks delivered, want 2", calls)
+	}
+	if s.notifier != nil {
+		t.Error("closeWatches should clear the notifier")
+	}
+}